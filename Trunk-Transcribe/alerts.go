@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// slack request signing secrets for the /scanner-alerts slash command in each workspace
+var slackSigningSecret string = os.Getenv("SLACK_SIGNING_SECRET")
+var slackSigningSecretSecondary string = os.Getenv("SLACK_SIGNING_SECRET_SECONDARY")
+
+// slash command payloads are a few hundred bytes, the body is read before it is authenticated
+const slackRequestLimit = 64 << 10
+
+// escaped slack channel reference of the form <#C06A28PMXFZ|scanner-dispatches>
+var slackChannelRefRegex = regexp.MustCompile(`^<#([CG][A-Z0-9]+)(\|[^>]*)?>$`)
+
+const alertsHelp = "Usage:\n" +
+	"`/scanner-alerts list`\n" +
+	"`/scanner-alerts add <keyword or phrase>`\n" +
+	"`/scanner-alerts remove <keyword or phrase>`\n" +
+	"`/scanner-alerts subscribe [#channel | talkgroup id]` (defaults to this channel)\n" +
+	"`/scanner-alerts unsubscribe [#channel | talkgroup id]`\n" +
+	"`/scanner-alerts notregex [regex]` (no regex clears it)"
+
+// alerts holds the self-service keyword alerts managed with the /scanner-alerts slash command
+var alerts = NewAlertsStore("")
+
+// AlertsStore persists one self-service NotifsRule per slack user as a NotifsConfig json file
+type AlertsStore struct {
+	path  string
+	mu    sync.Mutex
+	users map[SlackUserID]NotifsUser
+}
+
+// NewAlertsStore creates an empty store. If path is empty the store is only kept in memory.
+func NewAlertsStore(path string) *AlertsStore {
+	return &AlertsStore{
+		path:  path,
+		users: make(map[SlackUserID]NotifsUser),
+	}
+}
+
+// OpenAlertsStore loads the store at path, creating it on the first save if it does not exist
+func OpenAlertsStore(path string) (*AlertsStore, error) {
+	store := NewAlertsStore(path)
+
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return store, nil
+	case err != nil:
+		return nil, err
+	}
+
+	var config NotifsConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("alerts store %s: %w", path, err)
+	}
+	if config.Users != nil {
+		store.users = config.Users
+	}
+	return store, nil
+}
+
+// Rule returns the self-service rule of the user
+func (s *AlertsStore) Rule(userID SlackUserID) NotifsRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user := s.users[userID]; len(user.Notifs) > 0 {
+		return user.Notifs[0]
+	}
+	return NotifsRule{}
+}
+
+// Update validates the rule returned by fn and persists it as the user's self-service rule
+func (s *AlertsStore) Update(userID SlackUserID, name string, fn func(rule NotifsRule) (NotifsRule, error)) (NotifsRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.users[userID]
+	var rule NotifsRule
+	if len(user.Notifs) > 0 {
+		rule = user.Notifs[0]
+	}
+
+	rule, err := fn(rule)
+	if err != nil {
+		return rule, err
+	}
+	if err := validateAlertsRule(rule); err != nil {
+		return rule, err
+	}
+
+	previous, existed := s.users[userID]
+	s.users[userID] = NotifsUser{Name: name, Notifs: []NotifsRule{rule}}
+	if err := s.save(); err != nil {
+		if existed {
+			s.users[userID] = previous
+		} else {
+			delete(s.users, userID)
+		}
+		return rule, err
+	}
+	return rule, nil
+}
+
+// Notifs compiles the self-service rules. Rules without keywords are skipped since they can never
+// match.
+func (s *AlertsStore) Notifs() map[SlackUserID]Notifs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notifs := make(map[SlackUserID]Notifs, len(s.users))
+	for userID, user := range s.users {
+		if len(user.Notifs) == 0 || len(user.Notifs[0].Include) == 0 {
+			continue
+		}
+		notif, err := user.Notifs[0].Compile(routing)
+		if err != nil {
+			log.Printf("Skipping alerts for user %s: %v", userID, err)
+			continue
+		}
+		notifs[userID] = notif
+	}
+	return notifs
+}
+
+// save writes the store to a temp file and renames it over the previous version
+func (s *AlertsStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(NotifsConfig{Users: s.users}, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func validateAlertsRule(rule NotifsRule) error {
+	if len(rule.Include) == 0 {
+		return nil
+	}
+	_, err := rule.Compile(routing)
+	return err
+}
+
+// verifySlackRequest checks the request signature against each configured signing secret and
+// returns the request body
+func verifySlackRequest(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, secret := range []string{slackSigningSecret, slackSigningSecretSecondary} {
+		if secret == "" {
+			continue
+		}
+		verifier, err := slack.NewSecretsVerifier(r.Header, secret)
+		if err != nil {
+			return nil, err
+		}
+		verifier.Write(body)
+		if verifier.Ensure() == nil {
+			return body, nil
+		}
+	}
+	return nil, errors.New("invalid slack request signature")
+}
+
+// handleAlertsCommand serves the /scanner-alerts slash command
+func handleAlertsCommand(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, slackRequestLimit)
+	body, err := verifySlackRequest(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Println("Rejected slash command: ", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = io.NopCloser(strings.NewReader(string(body)))
+	cmd, err := slack.SlashCommandParse(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	text, err := runAlertsCommand(cmd)
+	if err != nil {
+		text = "Error: " + err.Error() + "\n" + alertsHelp
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         text,
+	})
+}
+
+// runAlertsCommand applies the slash command to the user's self-service rule and returns the reply
+func runAlertsCommand(cmd slack.SlashCommand) (string, error) {
+	userID := SlackUserID(cmd.UserID)
+	action, arg, _ := strings.Cut(strings.TrimSpace(cmd.Text), " ")
+	arg = strings.TrimSpace(arg)
+
+	var update func(rule NotifsRule) (NotifsRule, error)
+	switch strings.ToLower(action) {
+	case "", "list":
+		return describeAlertsRule(alerts.Rule(userID)), nil
+	case "help":
+		return alertsHelp, nil
+	case "add":
+		if arg == "" {
+			return "", errors.New("missing keyword")
+		}
+		update = func(rule NotifsRule) (NotifsRule, error) {
+			if !containsFold(rule.Include, arg) {
+				rule.Include = append(rule.Include, arg)
+			}
+			return rule, nil
+		}
+	case "remove":
+		if arg == "" {
+			return "", errors.New("missing keyword")
+		}
+		update = func(rule NotifsRule) (NotifsRule, error) {
+			rule.Include = slices.DeleteFunc(rule.Include, func(keyword string) bool {
+				return strings.EqualFold(keyword, arg)
+			})
+			return rule, nil
+		}
+	case "subscribe", "unsubscribe":
+		subscribe := strings.ToLower(action) == "subscribe"
+		channel, talkgroup, err := parseAlertsTarget(arg, cmd.ChannelID)
+		if err != nil {
+			return "", err
+		}
+		update = func(rule NotifsRule) (NotifsRule, error) {
+			switch {
+			case talkgroup != 0 && subscribe && !slices.Contains(rule.TalkGroups, talkgroup):
+				rule.TalkGroups = append(rule.TalkGroups, talkgroup)
+			case talkgroup != 0 && !subscribe:
+				rule.TalkGroups = slices.DeleteFunc(rule.TalkGroups, func(tg TalkGroupID) bool { return tg == talkgroup })
+			case channel != "" && subscribe && !slices.Contains(rule.Channels, channel):
+				rule.Channels = append(rule.Channels, channel)
+			case channel != "" && !subscribe:
+				rule.Channels = slices.DeleteFunc(rule.Channels, func(c string) bool { return c == channel })
+			}
+			return rule, nil
+		}
+	case "notregex":
+		if _, err := regexp.Compile(arg); err != nil {
+			return "", fmt.Errorf("invalid regex %q: %w", arg, err)
+		}
+		update = func(rule NotifsRule) (NotifsRule, error) {
+			rule.NotRegex = arg
+			return rule, nil
+		}
+	default:
+		return "", fmt.Errorf("unknown command %q", action)
+	}
+
+	rule, err := alerts.Update(userID, cmd.UserName, update)
+	if err != nil {
+		return "", err
+	}
+	publishNotifs()
+	return describeAlertsRule(rule), nil
+}
+
+// parseAlertsTarget parses a talkgroup id or channel reference, defaulting to the current channel
+func parseAlertsTarget(arg string, currentChannel string) (channel string, talkgroup TalkGroupID, err error) {
+	if arg == "" {
+		return currentChannel, 0, nil
+	}
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil && id > 0 {
+		return "", TalkGroupID(id), nil
+	}
+	if match := slackChannelRefRegex.FindStringSubmatch(arg); match != nil {
+		return match[1], 0, nil
+	}
+	if _, err := routing.Channel(arg); err != nil {
+		return "", 0, err
+	}
+	return arg, 0, nil
+}
+
+func describeAlertsRule(rule NotifsRule) string {
+	var lines []string
+	if len(rule.Include) == 0 {
+		lines = append(lines, "You have no keyword alerts. Add one with `/scanner-alerts add <keyword>`.")
+	} else {
+		lines = append(lines, "Keywords: "+strings.Join(rule.Include, ", "))
+	}
+
+	var targets []string
+	for _, channel := range rule.Channels {
+		if slackChannelIDRegex.MatchString(channel) {
+			channel = "<#" + channel + ">"
+		}
+		targets = append(targets, channel)
+	}
+	for _, tg := range rule.TalkGroups {
+		targets = append(targets, "talkgroup "+strconv.FormatInt(int64(tg), 10))
+	}
+	if len(targets) == 0 {
+		lines = append(lines, "Not subscribed to any channels or talkgroups. Subscribe with `/scanner-alerts subscribe`.")
+	} else {
+		lines = append(lines, "Listening on: "+strings.Join(targets, ", "))
+	}
+
+	if rule.NotRegex != "" {
+		lines = append(lines, "Ignoring text matching: `"+rule.NotRegex+"`")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(v string) bool {
+		return strings.EqualFold(v, value)
+	})
+}
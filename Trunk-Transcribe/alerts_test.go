@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signedSlackRequest signs the body the way slack does for the given secret
+func signedSlackRequest(t *testing.T, secret string, body string) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+
+	req, err := http.NewRequest("POST", "/slack/scanner-alerts", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestAlertsCommand(t *testing.T) {
+	slackSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+	defer func() {
+		slackSigningSecret = ""
+		alerts = NewAlertsStore("")
+		publishNotifs()
+	}()
+
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	alerts = NewAlertsStore(path)
+
+	fixture, err := os.ReadFile("testdata/slash_command_add.txt")
+	require.NoError(t, err)
+
+	mux := mux(nil, nil)
+
+	t.Run("bad signature", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, signedSlackRequest(t, "wrong secret", string(fixture)))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("oversized body", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, signedSlackRequest(t, slackSigningSecret, string(fixture)+strings.Repeat("x", slackRequestLimit)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("add keyword", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, signedSlackRequest(t, slackSigningSecret, string(fixture)))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var msg slack.Msg
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &msg))
+		assert.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+		assert.Contains(t, msg.Text, "Keywords: bike versus")
+	})
+
+	t.Run("subscribe to current channel", func(t *testing.T) {
+		body := strings.Replace(string(fixture), "text=add+bike+versus", "text=subscribe", 1)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, signedSlackRequest(t, slackSigningSecret, body))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var msg slack.Msg
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &msg))
+		assert.Contains(t, msg.Text, "Listening on: <#C06A28PMXFZ>")
+	})
+
+	t.Run("matches text", func(t *testing.T) {
+		meta := ExtractSlackMeta(Metadata{AudioText: "Bike versus auto on Shattuck"}, BERKELEY, currentNotifs())
+		assert.Contains(t, meta.Mentions, "<@U2147483697>")
+	})
+
+	t.Run("invalid notregex", func(t *testing.T) {
+		body := strings.Replace(string(fixture), "text=add+bike+versus", "text=notregex+%28bike", 1)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, signedSlackRequest(t, slackSigningSecret, body))
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid regex")
+	})
+
+	t.Run("persisted", func(t *testing.T) {
+		store, err := OpenAlertsStore(path)
+		require.NoError(t, err)
+		rule := store.Rule("U2147483697")
+		assert.Equal(t, []string{"bike versus"}, rule.Include)
+		assert.Equal(t, []string{"C06A28PMXFZ"}, rule.Channels)
+		assert.Empty(t, rule.NotRegex)
+	})
+}
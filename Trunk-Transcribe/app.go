@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"embed"
 	"encoding/json"
@@ -14,7 +15,6 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -22,22 +22,33 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/slack-go/slack"
-	"golang.org/x/sync/errgroup"
 )
 
 var r2Key string = os.Getenv("CLOUDFLARE_R2_KEY")
 var r2Secret string = os.Getenv("CLOUDFLARE_R2_SECRET")
-var r2Path string = "https://pub-85c4b9a9667540e99c0109c068c47e0f.r2.dev"
+
+// base url of this service, for links to the audio player
+var publicURL string = getenv("PUBLIC_URL", "https://trunk-transcribe.fly.dev")
+
+// directory for persistent state such as the self-service alerts store
+var dataDir string = getenv("DATA_DIR", "data")
+
+// number of workers processing queued transcription requests
+var workerCount int = getenvInt("WORKER_COUNT", 4)
+
+// maximum number of pending transcription requests before ingest responds with 503
+var queueDepth int = getenvInt("QUEUE_DEPTH", 500)
+
+// seconds upstream should wait before retrying when the queue is full
+var queueRetryAfter int = getenvInt("QUEUE_RETRY_AFTER", 30)
+
+// times a queued request is claimed before it is moved to the dead letter store
+var queueMaxAttempts int = getenvInt("QUEUE_MAX_ATTEMPTS", 3)
 
 // slack setup
 var slackapiSecret string = os.Getenv("SLACK_API_SECRET")
@@ -49,9 +60,16 @@ var resources embed.FS
 var t = template.Must(template.ParseFS(resources, "templates/*"))
 
 type Config struct {
-	uploader             *s3manager.Uploader
+	store                BlobStore
 	slackClient          *slack.Client
 	slackClientSecondary *slack.Client
+	deadLetters          *DeadLetters
+	transcribers         *Transcribers
+	enhancer             *Enhancer
+	archive              *Archive
+	quality              *QualityLog
+	incidents            *Incidents
+	collisions           *CollisionLog
 }
 
 var dedupeCache *lru.Cache[string, bool]
@@ -90,47 +108,167 @@ func main() {
 		log.Fatal("Missing SLACK_API_SECRET_SECONDARY")
 	}
 
-	// R2 setup
-	endpoint := fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cloudflareAccountID)
-	fmt.Println("Using cloudflare R2 endpoint: ", endpoint)
+	if routingConfigPath != "" {
+		r, err := LoadRouting(routingConfigPath)
+		if err != nil {
+			log.Fatal("Invalid routing config: ", err)
+		}
+		routing = r
+		log.Println("Using routing config: ", routingConfigPath)
+	}
+
+	if geocoderDataPath != "" {
+		g, err := LoadGeocoder(geocoderDataPath)
+		if err != nil {
+			log.Fatal("Invalid geocoder data: ", err)
+		}
+		geocoder = g
+		log.Println("Using geocoder data: ", geocoderDataPath)
+	}
+
+	if gazetteerDir != "" {
+		g, err := LoadGazetteer(gazetteerDir)
+		if err != nil {
+			log.Fatal("Invalid gazetteer: ", err)
+		}
+		gazetteer = g
+		log.Println("Using gazetteer: ", gazetteerDir)
+	}
+
+	if codesConfigPath != "" {
+		c, err := LoadCodeTable(codesConfigPath)
+		if err != nil {
+			log.Fatal("Invalid code table: ", err)
+		}
+		codeTable = c
+		log.Println("Using code table: ", codesConfigPath)
+	}
+
+	if notificationsConfigPath != "" {
+		n, err := LoadNotifications(notificationsConfigPath, routing)
+		if err != nil {
+			log.Fatal("Invalid notifications config: ", err)
+		}
+		notifications = n
+		log.Println("Using notifications config: ", notificationsConfigPath)
+	}
+
+	if notifsConfigPath != "" {
+		if err := reloadNotifs(notifsConfigPath); err != nil {
+			log.Fatal("Invalid notifs config: ", err)
+		}
+	}
 
-	r2Config := &aws.Config{
-		Region:      aws.String("auto"),
-		Credentials: credentials.NewStaticCredentials(r2Key, r2Secret, ""),
-		Endpoint:    aws.String(endpoint),
+	store, err := OpenAlertsStore(filepath.Join(dataDir, "alerts.json"))
+	if err != nil {
+		log.Fatal("Error opening alerts store: ", err)
+	}
+	alerts = store
+	publishNotifs()
+
+	blobs, err := newBlobStore()
+	if err != nil {
+		log.Fatal("Error creating blob store: ", err)
+	}
+
+	transcribersConfig, err := LoadTranscribersConfig(transcribersConfigPath)
+	if err != nil {
+		log.Fatal("Invalid transcribers config: ", err)
+	}
+	transcribers, err := NewTranscribers(transcribersConfig,
+		&CloudflareWhisper{URL: cloudflareWhisperUrl, Token: cloudflareApiToken},
+		&Gemini{APIKey: geminiApiKey, Model: "gemini-1.5-pro"},
+		&LocalWhisper{Command: localWhisperCommand, Args: localWhisperArgs},
+	)
+	if err != nil {
+		log.Fatal("Invalid transcribers config: ", err)
+	}
+
+	enhanceConfig, err := LoadEnhanceConfig(enhanceConfigPath)
+	if err != nil {
+		log.Fatal("Invalid enhance config: ", err)
+	}
+	enhancer, err := NewEnhancer(enhanceConfig)
+	if err != nil {
+		log.Fatal("Invalid enhance config: ", err)
 	}
-	uploader := s3manager.NewUploader(session.New(r2Config))
 
 	config := &Config{
-		uploader:             uploader,
+		store:                blobs,
 		slackClient:          api,
 		slackClientSecondary: secondary,
+		transcribers:         transcribers,
+		enhancer:             enhancer,
+		incidents:            NewIncidents(incidentWindow, incidentMaxAge),
 	}
 
-	ch := make(chan *TranscriptionRequest)
-	var wg sync.WaitGroup
+	db, err := OpenDB(filepath.Join(dataDir, "trunk-transcribe.db"))
+	if err != nil {
+		log.Fatal("Error opening database: ", err)
+	}
+	defer db.Close()
+
+	queue, err := NewQueue(context.Background(), db, queueDepth)
+	if err != nil {
+		log.Fatal("Error opening transcription queue: ", err)
+	}
 
-	// start transcription request goroutine pool
+	config.deadLetters, err = NewDeadLetters(context.Background(), db)
+	if err != nil {
+		log.Fatal("Error opening dead letter store: ", err)
+	}
+
+	config.archive, err = NewArchive(context.Background(), db)
+	if err != nil {
+		log.Fatal("Error opening call archive: ", err)
+	}
+
+	config.quality, err = NewQualityLog(context.Background(), db)
+	if err != nil {
+		log.Fatal("Error opening signal quality log: ", err)
+	}
+
+	config.collisions, err = NewCollisionLog(context.Background(), db, incidentWindow)
+	if err != nil {
+		log.Fatal("Error opening collision log: ", err)
+	}
+
+	// start transcription request workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	workersDone := make(chan struct{})
 	go func() {
+		queue.Work(workerCtx, workerCount, func(req *TranscriptionRequest) {
+			handleTranscriptionRequest(context.Background(), config, req)
+		}, func(req *TranscriptionRequest, err error) {
+			if err := config.deadLetters.Add(context.Background(), req, stepQueue, err); err != nil {
+				log.Printf("[deadLetter] Error recording dead letter for %s: %v", req.Filename, err)
+			}
+		})
+		close(workersDone)
+	}()
 
-		for req := range ch {
-			var requestsInFlight atomic.Int64
-			wg.Add(1)
-			requestsInFlight.Add(1)
-			fmt.Println("Requests in flight: ", requestsInFlight.Load())
-			go func() {
-				ctx := context.Background()
-				handleTranscriptionRequest(ctx, config, req)
-				wg.Done()
-				requestsInFlight.Add(-1)
-			}()
+	// watch notification rules for changes
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	if notifsConfigPath != "" {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go watchNotifs(watchCtx, notifsConfigPath, 30*time.Second, hupChan)
+	}
+
+	// post the weekly collision summary
+	if collisionSummaryChannel != "" {
+		channel, err := routing.Channel(collisionSummaryChannel)
+		if err != nil {
+			log.Fatal("Invalid COLLISION_SUMMARY_CHANNEL: ", err)
 		}
-	}()
+		go postCollisionSummaries(watchCtx, config, channel)
+	}
 
 	// create server to serve http requests
 	server := &http.Server{
 		Addr:    ":8080",
-		Handler: mux(config, ch),
+		Handler: mux(config, queue),
 	}
 
 	log.Println("Starting server on port: ", port)
@@ -155,17 +293,16 @@ func main() {
 		log.Fatalf("HTTP shutdown error: %v", err)
 	}
 
-	// close TranscriptionRequest channel
-	close(ch)
-
-	// wait for transcription request go routines to complete
-	wg.Wait()
+	// stop claiming queued requests and wait for in flight requests to complete. Anything left in
+	// the queue is replayed on the next start
+	stopWorkers()
+	<-workersDone
 
 	log.Println("Graceful shutdown complete.")
 }
 
 // mux creates a new ServeMux router
-func mux(config *Config, ch chan *TranscriptionRequest) *http.ServeMux {
+func mux(config *Config, queue *Queue) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -182,20 +319,25 @@ func mux(config *Config, ch chan *TranscriptionRequest) *http.ServeMux {
 			return
 		}
 
-		result, err := url.JoinPath(r2Path, link[0])
+		result, err := audioURL(config, link[0])
 		if err != nil {
 			writeErr(w, err)
 			return
 		}
 		data := map[string]string{
 			"Link": result,
+			"Type": audioContentType(link[0]),
 		}
 		t.ExecuteTemplate(w, "audio.html.tmpl", data)
 	})
 
-	mux.HandleFunc("/transcribe", func(w http.ResponseWriter, r *http.Request) {
-		defer http.ServeContent(w, r, "", time.Now(), strings.NewReader("ok"))
+	if local, ok := config.blobStore().(*LocalStore); ok {
+		mux.Handle("GET "+localFilesPath, local)
+	}
 
+	mux.HandleFunc("POST /slack/scanner-alerts", handleAlertsCommand)
+
+	mux.HandleFunc("/transcribe", func(w http.ResponseWriter, r *http.Request) {
 		r.Body = http.MaxBytesReader(w, r.Body, 20<<20+512)
 		req, err := createTranscriptionRequestFromTrunkRecorder(r.Context(), config, r)
 		if err != nil {
@@ -203,24 +345,72 @@ func mux(config *Config, ch chan *TranscriptionRequest) *http.ServeMux {
 			writeErr(w, err)
 			return
 		}
-		ch <- req
+		if !enqueue(w, r, queue, req) {
+			return
+		}
+		writeOK(w, r)
 	})
 
 	mux.HandleFunc("/transcribe/api/call-upload", func(w http.ResponseWriter, r *http.Request) {
-		defer http.ServeContent(w, r, "", time.Now(), strings.NewReader("ok"))
-
 		r.Body = http.MaxBytesReader(w, r.Body, 20<<20+512)
 		req, err := createTranscriptionRequestFromRdio(r.Context(), config, r)
 		if err != nil {
 			log.Println("Error creating transcription request: ", err.Error())
+			writeOK(w, r)
+			return
+		}
+		if !enqueue(w, r, queue, req) {
+			return
+		}
+		writeOK(w, r)
+	})
+
+	mux.HandleFunc("GET /admin/dead-letters", handleListDeadLetters(config))
+	mux.HandleFunc("POST /admin/dead-letters/{id}/replay", handleReplayDeadLetter(config, queue))
+	mux.HandleFunc("POST /admin/archive/rebuild", handleRebuildArchive(config))
+
+	mux.HandleFunc("GET /api/search", handleSearch(config))
+	mux.HandleFunc("GET /api/quality", handleQuality(config))
+	mux.HandleFunc("GET /api/collisions", handleCollisions(config))
+	mux.HandleFunc("GET /calls", handleTalkgroups(config))
+	mux.HandleFunc("GET /calls/{talkgroup}", handleTimeline(config))
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		queueStats, err := queue.Stats(r.Context())
+		if err != nil {
+			writeErr(w, err)
 			return
 		}
-		ch <- req
+		stats := struct {
+			QueueStats
+			Filter FilterStats `json:"filter"`
+		}{queueStats, filterStats.Snapshot()}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
 	})
 
 	return mux
 }
 
+// enqueue adds the request to the queue. If the queue is full it responds with 503 and a
+// Retry-After header so upstream recorders back off instead of blocking. Returns whether the
+// request was queued.
+func enqueue(w http.ResponseWriter, r *http.Request, queue *Queue, req *TranscriptionRequest) bool {
+	err := queue.Enqueue(r.Context(), req)
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		log.Println("Rejecting transcription request, queue is full: ", req.Filename)
+		w.Header().Set("Retry-After", strconv.Itoa(queueRetryAfter))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return false
+	case err != nil:
+		log.Println("Error queueing transcription request: ", err.Error())
+		writeErr(w, err)
+		return false
+	}
+	return true
+}
+
 func createTranscriptionRequestFromRdio(ctx context.Context, config *Config, r *http.Request) (*TranscriptionRequest, error) {
 
 	var call Call
@@ -285,6 +475,8 @@ loop:
 		Transcribe:    transcribe,
 		SlackChannels: channels,
 		UploadToRdio:  false,
+		Notify:        len(channels) == len(resolved), // calls with Berkeley channels are notified by trunk-recorder
+		Sinks:         callSinks(metadata),
 	}
 
 	data, _ := call.ToJson()
@@ -338,6 +530,8 @@ func createTranscriptionRequestFromTrunkRecorder(ctx context.Context, config *Co
 		Transcribe:    true,
 		SlackChannels: channels,
 		UploadToRdio:  true,
+		Notify:        len(channels) > 0, // calls without Berkeley channels are notified by rdio
+		Sinks:         callSinks(metadata),
 	}, nil
 }
 
@@ -356,44 +550,43 @@ func handleTranscriptionRequest(ctx context.Context, config *Config, req *Transc
 		}
 	}()
 
-	enhanceCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
-	defer cancel()
-
-	enhanced, enhanceErr := io.ReadAll(removeSilence(enhanceCtx, req.Data))
-	// enhanced, enhanceErr := deepFilter(enhanceCtx, req.Data)
-
-	if enhanceErr != nil {
-		log.Println("[handleTranscriptionRequest] Error performing enhancement on audio. Falling back to original. ", enhanceErr)
-	} else {
-		req.Data = enhanced
+	// dead letter replays were recorded the first time round
+	if len(req.Steps) == 0 {
+		if err := config.quality.Record(ctx, req.Meta); err != nil {
+			log.Println("[handleTranscriptionRequest] Error recording signal quality: ", err)
+		}
 	}
 
+	var transcribeErr, rdioErr error
 	var wg sync.WaitGroup
+	wg.Add(2)
 	go func() {
-		wg.Add(1)
-		err = transcribeAndUpload(ctx, config, req)
-		wg.Done()
-		if err != nil {
-			fmt.Println("[handleTranscriptionRequest]Error transcribing and uploading to slack: ", err.Error())
+		defer wg.Done()
+		transcribeErr = transcribeAndUpload(ctx, config, req)
+		if transcribeErr != nil {
+			fmt.Println("[handleTranscriptionRequest]Error transcribing and uploading to slack: ", transcribeErr.Error())
 		}
 	}()
 
 	go func() {
-		wg.Add(1)
-		err = uploadToRdio(ctx, req)
-		wg.Done()
-		if err != nil {
-			fmt.Println("[handleTranscriptionRequest] Error uploading to rdio: ", err.Error())
+		defer wg.Done()
+		rdioErr = uploadToRdio(ctx, config, req)
+		if rdioErr != nil {
+			fmt.Println("[handleTranscriptionRequest] Error uploading to rdio: ", rdioErr.Error())
 		}
 	}()
 
 	wg.Wait()
-	return nil
+	err = errors.Join(transcribeErr, rdioErr)
+	return err
 }
 
 // dedupeDispatch checks if the specified dispatch (described by its metadata) has already been seen.
-// Returns true if the dispatch is a duplicate, false otherwise.
+// Returns true if the dispatch is a duplicate, false otherwise. Emergencies are never duplicates.
 func dedupeDispatch(meta Metadata) (dupe bool) {
+	if meta.IsEmergency() {
+		return false
+	}
 
 	// construct a cache key consisting of all the srcs (parties in the call),
 	// the talkgroup, and the startime
@@ -414,90 +607,160 @@ func dedupeDispatch(meta Metadata) (dupe bool) {
 // transcribeAndUpload transcribes the audio to text, posts the text to slack and persists the audio file to S3,
 func transcribeAndUpload(ctx context.Context, config *Config, req *TranscriptionRequest) error {
 
-	key := req.FilePath()
-	data := req.Data
 	metadata := req.Meta
 
-	if len(req.SlackChannels) == 0 {
+	if len(req.SlackChannels) == 0 && !notifiable(req) {
 		return nil
-	} else if !req.Transcribe {
-		return postToSlack(ctx, config, req.SlackChannels, key, data, metadata)
 	}
 
-	msg, segments, err := whisper(ctx, req.Data)
+	// archive and post compact audio, the original is transcribed and sent to rdio
+	key, data, contentType := storedAudio(ctx, req.FilePath(), req.Data)
+	if !req.Transcribe {
+		var slackErr, notifyErr error
+		if req.Runs(stepSlack) {
+			_, slackErr = slackStep(ctx, config, req, key, data, metadata)
+		}
+		if req.Runs(stepNotify) {
+			notifyErr = notifyStep(ctx, config, req, metadata)
+		}
+		return errors.Join(slackErr, notifyErr)
+	}
 
-	if err == nil {
-		fmt.Println(key+": ", msg)
-	} else {
-		msg = "Error transcribing text: " + err.Error()
+	metadata.URL = fmt.Sprintf("%s/audio?link=%s", publicURL, key)
+
+	var transcribeErr error
+	switch {
+	case metadata.PoorSignal() && qualityAction == qualitySkip:
+		log.Printf("Not transcribing %s, poor signal", key)
+	case req.Runs(stepTranscribe):
+		var transcription Transcription
+		audio, trimmed := config.enhancer.Enhance(ctx, metadata, req.Data)
+		err := retry(ctx, stepTranscribe, func(ctx context.Context) (err error) {
+			transcription, err = config.transcribers.Transcribe(ctx, metadata, audio)
+			return err
+		})
+
+		msg := transcription.Text
+		if err == nil {
+			fmt.Printf("%s [%s]: %s\n", key, transcription.Backend, msg)
+		} else {
+			// still post the audio, slack shows it could not be transcribed. The failure is
+			// dead-lettered once the call is posted, so the replay can reply to the posts
+			transcribeErr = err
+		}
+
+		metadata.AudioText = msg
+		metadata.Transcriber = transcription.Backend
+		metadata.DroppedSegments = transcription.Dropped
+		metadata.Segments = offsetSegments(transcription.Segments, trimmed) // back onto the srcList timeline
 	}
 
-	metadata.AudioText = msg
-	metadata.Segments = segments
-	metadata.URL = fmt.Sprintf("https://trunk-transcribe.fly.dev/audio?link=%s", key)
+	if req.Runs(stepTranscribe) {
+		recordCollision(ctx, config, key, metadata)
+	}
 
-	wg, gctx := errgroup.WithContext(ctx)
+	var r2Err, slackErr, notifyErr error
+	var posted map[SlackChannelID]string
+	var wg sync.WaitGroup
 
 	//upload to Cloudflare R2 (with s3 compatible api)
-	wg.Go(func() error {
-		return uploadS3(gctx, config.uploader, key, bytes.NewReader(data), metadata)
-	})
+	if req.Runs(stepR2) {
+		archiveCall(ctx, config, key, metadata)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r2Err = retry(ctx, stepR2, func(ctx context.Context) error {
+				return uploadAudio(ctx, config.store, key, bytes.NewReader(data), contentType, metadata)
+			})
+			if r2Err == nil {
+				sidecar := newSidecar(key, metadata, req.SlackChannels)
+				r2Err = retry(ctx, stepR2, func(ctx context.Context) error {
+					return uploadSidecar(ctx, config.store, sidecar)
+				})
+			}
+			if r2Err != nil {
+				failed := *req
+				failed.Meta = metadata
+				deadLetter(ctx, config, &failed, stepR2, r2Err)
+			}
+		}()
+	}
 
-	wg.Go(func() error {
-		return postToSlack(gctx, config, req.SlackChannels, key, data, metadata)
-	})
+	if req.Runs(stepSlack) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			posted, slackErr = slackStep(ctx, config, req, key, data, metadata)
+		}()
+	}
 
-	err = wg.Wait()
-	return err
+	if req.Runs(stepNotify) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notifyErr = notifyStep(ctx, config, req, metadata)
+		}()
+	}
+
+	wg.Wait()
+
+	if transcribeErr != nil {
+		// the channels posted to get the transcript as a reply, and the sinks were already sent the
+		// call so only alerts matching the transcript are sent
+		failed := *req
+		failed.Posted = posted
+		failed.Sinks = nil
+		deadLetter(ctx, config, &failed, stepTranscribe, transcribeErr)
+	}
+	return errors.Join(r2Err, slackErr, notifyErr)
 }
 
-// transcribeAndUpload uploads the audio to S3
-func uploadS3(ctx context.Context, uploader *s3manager.Uploader, key string, reader io.Reader, meta Metadata) error {
+// slackStep posts the call to the request's slack channels, dead-lettering the channels that
+// could not be posted to. It returns the file ids of the posts by channel.
+func slackStep(ctx context.Context, config *Config, req *TranscriptionRequest, key string, data []byte, meta Metadata) (map[SlackChannelID]string, error) {
+	posted, err := postToSlack(ctx, config, req.SlackChannels, key, data, meta, req.Posted)
+
+	var postErr *SlackPostError
+	if errors.As(err, &postErr) {
+		failed := *req
+		failed.Meta = meta
+		failed.SlackChannels = postErr.Channels
+		deadLetter(ctx, config, &failed, stepSlack, err)
+	}
+	return posted, err
+}
 
-	s3Meta := make(map[string]*string)
-	s3Meta["short-name"] = aws.String(meta.ShortName)
-	s3Meta["call-length"] = aws.String(strconv.FormatInt(meta.CallLength, 10))
-	s3Meta["talk-group"] = aws.String(strconv.FormatInt(meta.Talkgroup, 10))
-	s3Meta["priority"] = aws.String(strconv.FormatInt(meta.Priority, 10))
+// uploadAudio persists the audio to the blob store
+func uploadAudio(ctx context.Context, store BlobStore, key string, reader io.Reader, contentType string, meta Metadata) error {
 
-	input := &s3manager.UploadInput{
-		Bucket:      aws.String("scanner-berkeley"),         // bucket's name
-		Key:         aws.String(key),                        // files destination location
-		Body:        reader,                                 // content of the file
-		Metadata:    s3Meta,                                 // metadata
-		ContentType: aws.String("application/octet-stream"), // content type
+	blobMeta := map[string]string{
+		"short-name":  meta.ShortName,
+		"call-length": strconv.FormatInt(meta.CallLength, 10),
+		"talk-group":  strconv.FormatInt(meta.Talkgroup, 10),
+		"priority":    strconv.FormatInt(meta.Priority, 10),
 	}
-	_, err := uploader.UploadWithContext(ctx, input)
-	return err
+	return store.Put(ctx, key, reader, contentType, blobMeta)
 }
 
-func postToSlack(ctx context.Context, config *Config, channelIDs []SlackChannelID, key string, data []byte, meta Metadata) error {
+// postToSlack posts the call to the channels and returns the file ids of the posts by channel.
+// Channels the call was already posted to, by the file id in replyTo, get the message as a thread
+// reply to that post instead.
+func postToSlack(ctx context.Context, config *Config, channelIDs []SlackChannelID, key string, data []byte, meta Metadata, replyTo map[SlackChannelID]string) (map[SlackChannelID]string, error) {
 
 	if len(channelIDs) == 0 {
 		log.Println("Skipping slack post for key: " + key)
-		return nil
+		return nil, nil
 	}
 
-	if meta.AudioText == "" {
+	if meta.AudioText == "" && meta.PoorSignal() && qualityAction == qualitySkip {
+		meta.AudioText = "Not transcribed, poor signal"
+	} else if meta.AudioText == "" {
 		meta.AudioText = "Could not transcribe audio"
 	}
 
-	blocks := meta.Segments
-
-	for i, block := range blocks {
-		if i >= len(meta.SrcList) {
-			break
-		} else if len(block) == 0 {
-			continue
-		}
-
-		src := meta.SrcList[i]
-		tag := src.Tag
-		if tag == "" {
-			tag = strconv.FormatInt(src.Src, 10)
-		}
-		block = strings.TrimSpace(block)
-		blocks[i] = tag + ": " + block
+	blocks := speakerTranscript(meta)
+	if len(blocks) == 0 {
+		blocks = []string{meta.AudioText}
 	}
 
 	// Talkgroup
@@ -507,11 +770,25 @@ func postToSlack(ctx context.Context, config *Config, channelIDs []SlackChannelI
 	// Mentions
 
 	blocks = append([]string{"*" + meta.TalkgroupTag + "* | _" + meta.TalkGroupDesc + "_"}, blocks...)
-	blocks = append(blocks, fmt.Sprintf("%d seconds | %s", meta.CallLength, time.Now().In(location).Format("Mon, Jan 02 2006 3:04PM MST")))
+	if meta.IsEmergency() {
+		blocks = append([]string{emergencyBanner(meta)}, blocks...)
+	}
+	info := fmt.Sprintf("%d seconds | %s", meta.CallLength, time.Now().In(location).Format("Mon, Jan 02 2006 3:04PM MST"))
+	if meta.Transcriber != "" {
+		info += " | transcribed by " + meta.Transcriber
+	}
+	if score, _ := meta.SignalQuality(); meta.PoorSignal() {
+		info += fmt.Sprintf(" | poor signal (quality %d)", score)
+	}
+	blocks = append(blocks, info)
 	if meta.URL != "" {
 		blocks = append(blocks, fmt.Sprintf("<%s|Audio>", meta.URL))
 	}
 
+	notifs := currentNotifs()
+	postErr := &SlackPostError{}
+	posted := make(map[SlackChannelID]string)
+
 	for _, channelID := range channelIDs {
 
 		client := config.slackClientSecondary
@@ -522,40 +799,109 @@ func postToSlack(ctx context.Context, config *Config, channelIDs []SlackChannelI
 			log.Printf("Posting channel: %s to secondary slack group", channelID)
 		}
 
-		slackMeta := ExtractSlackMeta(meta, channelID, notifsMap)
+		slackMeta := ExtractSlackMeta(meta, channelID, notifs)
 		mentions := slackMeta.Mentions
 		message := blocks
+		if details := slackMeta.Details; !details.IsZero() {
+			message = append(message, ":clipboard: "+details.String())
+		}
+		if addr := slackMeta.Address; addr.Location != nil {
+			message = append(message, fmt.Sprintf(":round_pushpin: <%s|%s>", addr.Location.MapURL(), addr.Normalized))
+		}
 		if str := strings.Join(mentions, " "); len(str) > 0 {
 			message = append(message, str)
 		}
 
 		sentences := strings.Join(message, "\n")
 
+		if fileID, ok := replyTo[channelID]; ok {
+			err := retry(ctx, stepSlack, func(ctx context.Context) error {
+				return replyToPost(ctx, client, channelID, fileID, sentences)
+			})
+			if err != nil {
+				log.Println("Error replying to slack post: ", err)
+				postErr.Channels = append(postErr.Channels, channelID)
+				postErr.Errs = append(postErr.Errs, err)
+			} else {
+				posted[channelID] = fileID
+			}
+			continue
+		}
+
+		// follow-up calls of an incident are threaded under its first call
+		incident, related := config.incidents.Correlate(channelID, meta, slackMeta.Address)
+		if related && incident.ThreadTS == "" {
+			ts, err := threadTS(ctx, client, channelID, incident.FileID)
+			if err != nil {
+				log.Printf("Error finding thread of incident %d, posting to channel: %v", incident.ID, err)
+			} else {
+				incident.ThreadTS = ts
+				config.incidents.SetThread(channelID, incident.ID, ts)
+			}
+		}
+
 		// upload audio
-		summary, err := client.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
-			Filename:       filepath.Base(key),
-			FileSize:       len(data),
-			Reader:         bytes.NewReader(data),
-			InitialComment: sentences,
-			Channel:        string(channelID),
+		var summary *slack.FileSummary
+		err := retry(ctx, stepSlack, func(ctx context.Context) (err error) {
+			summary, err = client.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
+				Filename:        filepath.Base(key),
+				FileSize:        len(data),
+				Reader:          bytes.NewReader(data),
+				InitialComment:  sentences,
+				Channel:         string(channelID),
+				ThreadTimestamp: incident.ThreadTS,
+			})
+			return err
 		})
 		if err != nil {
 			log.Println("Error uploading file to slack: ", err)
-			return err
+			postErr.Channels = append(postErr.Channels, channelID)
+			postErr.Errs = append(postErr.Errs, err)
 		} else {
 			b, _ := json.Marshal(summary)
 			log.Println("Sucessful post to slack: ", string(b))
+			posted[channelID] = summary.ID
+			if !related {
+				config.incidents.Open(channelID, meta, slackMeta.Address, summary.ID)
+			}
 		}
 
 	}
 
-	return nil
+	if len(postErr.Channels) > 0 {
+		return posted, postErr
+	}
+	return posted, nil
+}
+
+// replyToPost posts text as a thread reply to the post of the file in the channel
+func replyToPost(ctx context.Context, client *slack.Client, channelID SlackChannelID, fileID, text string) error {
+	ts, err := threadTS(ctx, client, channelID, fileID)
+	if err != nil {
+		return err
+	}
+	_, _, err = client.PostMessageContext(ctx, string(channelID), slack.MsgOptionText(text, false), slack.MsgOptionTS(ts))
+	return err
+}
+
+// SlackPostError lists the channels a post failed for
+type SlackPostError struct {
+	Channels []SlackChannelID
+	Errs     []error
+}
+
+func (e *SlackPostError) Error() string {
+	return fmt.Sprintf("slack post failed for channels %v: %v", e.Channels, errors.Join(e.Errs...))
+}
+
+func (e *SlackPostError) Unwrap() []error {
+	return e.Errs
 }
 
 // uploadToRdio uploads the audio file to the radio interface: https://rdio-eastbay.fly.dev
-func uploadToRdio(ctx context.Context, req *TranscriptionRequest) error {
+func uploadToRdio(ctx context.Context, config *Config, req *TranscriptionRequest) error {
 
-	if !req.UploadToRdio {
+	if !req.UploadToRdio || !req.Runs(stepRdio) {
 		return nil
 	}
 
@@ -575,8 +921,25 @@ func uploadToRdio(ctx context.Context, req *TranscriptionRequest) error {
 	io.Copy(part, reader)
 	writer.Close()
 
+	err := retry(ctx, stepRdio, func(ctx context.Context) error {
+		return postRdio(ctx, writer.FormDataContentType(), body.Bytes())
+	})
+	if err != nil {
+		deadLetter(ctx, config, req, stepRdio, err)
+		return fmt.Errorf("Error uploading to rdio-scanner: %w", err)
+	}
+	return nil
+}
+
+func postRdio(ctx context.Context, contentType string, body []byte) error {
 	uri := "https://rdio-eastbay.fly.dev/api/trunk-recorder-call-upload"
-	res, err := http.Post(uri, writer.FormDataContentType(), body)
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -584,7 +947,7 @@ func uploadToRdio(ctx context.Context, req *TranscriptionRequest) error {
 	defer res.Body.Close()
 
 	if res.StatusCode > 299 {
-		return fmt.Errorf("Error uploading to rdio-scanner: Response failed with status code: %d and\nbody: %s\n", res.StatusCode, resBody)
+		return newHTTPStatusError(res, resBody)
 	}
 	return nil
 }
@@ -596,7 +959,7 @@ func uploadToRdio(ctx context.Context, req *TranscriptionRequest) error {
 
 func ExtractSlackMeta(meta Metadata, channelID SlackChannelID, notifsMap map[SlackUserID][]Notifs) (slackMeta SlackMeta) {
 
-	text := strings.ToLower(meta.AudioText)
+	text := callText(meta)
 	words := wordsRegex.FindAllString(text, -1) //split text into words array
 
 	talkgroupID := TalkGroupID(meta.Talkgroup)
@@ -609,41 +972,60 @@ func ExtractSlackMeta(meta Metadata, channelID SlackChannelID, notifsMap map[Sla
 			}
 		}
 	}
+	slackMeta.Mentions = onCallMentions(meta, channelID, slackMeta.Mentions)
 
-	// match address
-	for i := 0; i < len(words); i += 1 {
-		prefix := words[i]
-		var word string
-		if len(words[i:]) >= 2 {
-			word = words[i+1]
-		}
-
-		hasAddrNumber := numericRegex.MatchString(prefix)
+	slackMeta.Address = gazetteer.Extract(meta, text)
+	slackMeta.Details = codeTable.Extract(text, slackMeta.Address)
+	slackMeta.Address = geocoder.Geocode(meta, slackMeta.Address)
+	return slackMeta
+}
 
-		for _, street := range streets {
-			lowerCase := strings.ToLower(street)
-			found := false
-			switch {
-			case hasAddrNumber && word == lowerCase:
-				slackMeta.Address.PrimaryAddress = prefix + " " + street
-				// incr index by one to move on to the next pair
-				i += 1
-				found = true
-			case prefix == lowerCase:
-				slackMeta.Address = slackMeta.Address.AppendStreet(street)
-				found = true
-			}
-			if found {
-				break
-			}
-		}
+// callText is the lowercase transcript of the call to extract from. Suspect segments are left out
+// and calls that are all hallucination have no text, so they mention no one.
+func callText(meta Metadata) string {
+	text := strings.ToLower(trustedText(meta.AudioText, meta.Segments))
+	if isHallucinatedText(text) {
+		return ""
+	}
+	return text
+}
 
+// blobStore returns the configured blob store, or the R2 bucket's public urls if there is none
+func (config *Config) blobStore() BlobStore {
+	if config == nil || config.store == nil {
+		return &S3Store{bucket: blobBucket, prefix: blobPrefix, publicURL: cmp.Or(blobPublicURL, r2Path)}
 	}
+	return config.store
+}
 
-	return slackMeta
+// audioURL returns the public url of the audio at key
+func audioURL(config *Config, key string) (string, error) {
+	return config.blobStore().URL(key)
+}
+
+func writeOK(w http.ResponseWriter, r *http.Request) {
+	http.ServeContent(w, r, "", time.Now(), strings.NewReader("ok"))
 }
 
 func writeErr(w http.ResponseWriter, err error) {
 	fmt.Println("Error: ", err.Error())
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
+
+// getenv returns the environment variable named by key, or fallback if it is unset or empty
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getenvInt returns the integer environment variable named by key, or fallback if it is unset or
+// invalid
+func getenvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
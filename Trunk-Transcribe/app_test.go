@@ -4,7 +4,6 @@ import (
     "context"
     "encoding/base64"
     "encoding/json"
-    "io"
     "io/ioutil"
     "net/http"
     "net/http/httptest"
@@ -282,8 +281,7 @@ func TestSilenceRemove(t *testing.T) {
             data, err := base64.StdEncoding.DecodeString(test.data)
             assert.NoError(t, err)
 
-            reader := removeSilence(ctx, data)
-            b, err := io.ReadAll(reader)
+            b, err := EnhanceStage{Stage: stageSilence}.Run(ctx, data)
             assert.NoError(t, err)
 
             assert.Less(t, len(b), len(data))
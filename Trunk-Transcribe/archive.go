@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// page size limits for /api/search
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// Archive indexes every processed call in sqlite so transcripts can be searched after they have
+// scrolled out of Slack
+type Archive struct {
+	db *sql.DB
+}
+
+// ArchivedCall is a call stored in the archive
+type ArchivedCall struct {
+	Key          string           `json:"key"`
+	URL          string           `json:"url"`
+	StartTime    time.Time        `json:"start_time"`
+	CallLength   int64            `json:"call_length"`
+	Talkgroup    int64            `json:"talkgroup"`
+	TalkgroupTag string           `json:"talkgroup_tag,omitempty"`
+	System       string           `json:"system,omitempty"`
+	Emergency    bool             `json:"emergency,omitempty"`
+	Units        []int64          `json:"units,omitempty"`
+	Incident     *IncidentDetails `json:"incident,omitempty"` // extracted from the transcript
+	Text         string           `json:"text,omitempty"`
+	Snippet      string           `json:"snippet,omitempty"` // matched text highlighted with [brackets]
+	Meta         *Metadata        `json:"-"`
+}
+
+// SearchQuery filters archived calls. Zero fields are not filtered on.
+type SearchQuery struct {
+	Text      string
+	Talkgroup int64
+	System    string
+	Agency    string // talkgroup_group
+	Emergency bool   // only emergency calls
+	Type      string // incident type, e.g. "Robbery"
+	Status    string // status of the units, e.g. "on scene"
+	Unit      int64
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Offset    int
+}
+
+// SearchResults is a page of archived calls, newest first
+type SearchResults struct {
+	Total      int64          `json:"total"`
+	Results    []ArchivedCall `json:"results"`
+	NextOffset int            `json:"next_offset,omitempty"`
+}
+
+func NewArchive(ctx context.Context, db *sql.DB) (*Archive, error) {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS calls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key TEXT NOT NULL UNIQUE,
+			start_time INTEGER NOT NULL,
+			call_length INTEGER NOT NULL,
+			talkgroup INTEGER NOT NULL,
+			talkgroup_tag TEXT NOT NULL,
+			system TEXT NOT NULL,
+			agency TEXT NOT NULL,
+			emergency INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			metadata BLOB NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS calls_start_time ON calls (start_time);
+		CREATE INDEX IF NOT EXISTS calls_talkgroup ON calls (talkgroup, start_time);
+
+		CREATE TABLE IF NOT EXISTS call_units (
+			call_id INTEGER NOT NULL REFERENCES calls (id),
+			src INTEGER NOT NULL,
+			PRIMARY KEY (src, call_id)
+		);
+
+		CREATE VIRTUAL TABLE IF NOT EXISTS calls_fts USING fts5(text, content='calls', content_rowid='id');
+		CREATE TRIGGER IF NOT EXISTS calls_ai AFTER INSERT ON calls BEGIN
+			INSERT INTO calls_fts (rowid, text) VALUES (new.id, new.text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS calls_ad AFTER DELETE ON calls BEGIN
+			INSERT INTO calls_fts (calls_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS calls_au AFTER UPDATE ON calls BEGIN
+			INSERT INTO calls_fts (calls_fts, rowid, text) VALUES ('delete', old.id, old.text);
+			INSERT INTO calls_fts (rowid, text) VALUES (new.id, new.text);
+		END;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range []string{"incident_type", "status"} {
+		if err := addColumn(ctx, db, "calls", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return nil, err
+		}
+	}
+	return &Archive{db: db}, nil
+}
+
+// Add stores the call, replacing any previous version archived under the same key
+func (a *Archive) Add(ctx context.Context, key string, meta Metadata) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	details := callDetails(meta)
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO calls (key, start_time, call_length, talkgroup, talkgroup_tag, system, agency, emergency, text, metadata, incident_type, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			start_time = excluded.start_time,
+			call_length = excluded.call_length,
+			talkgroup = excluded.talkgroup,
+			talkgroup_tag = excluded.talkgroup_tag,
+			system = excluded.system,
+			agency = excluded.agency,
+			emergency = excluded.emergency,
+			text = excluded.text,
+			metadata = excluded.metadata,
+			incident_type = excluded.incident_type,
+			status = excluded.status
+		RETURNING id
+	`, key, meta.StartTime, meta.CallLength, meta.Talkgroup, meta.TalkgroupTag, meta.ShortName,
+		meta.TalkGroupGroup, meta.IsEmergency(), trustedText(meta.AudioText, meta.Segments), b,
+		details.Type, details.Status).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM call_units WHERE call_id = ?`, id); err != nil {
+		return err
+	}
+	for _, src := range meta.SrcList {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO call_units (call_id, src) VALUES (?, ?)`, id, src.Src); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// where returns the sql WHERE clause and its arguments selecting the calls matching the query
+func (query SearchQuery) where() (string, []any) {
+	var where []string
+	var args []any
+	if text := ftsQuery(query.Text); text != "" {
+		where = append(where, `calls.id IN (SELECT rowid FROM calls_fts WHERE calls_fts MATCH ?)`)
+		args = append(args, text)
+	}
+	if query.Talkgroup != 0 {
+		where = append(where, `talkgroup = ?`)
+		args = append(args, query.Talkgroup)
+	}
+	if query.System != "" {
+		where = append(where, `system = ? COLLATE NOCASE`)
+		args = append(args, query.System)
+	}
+	if query.Agency != "" {
+		where = append(where, `agency = ? COLLATE NOCASE`)
+		args = append(args, query.Agency)
+	}
+	if query.Emergency {
+		where = append(where, `emergency`)
+	}
+	if query.Type != "" {
+		where = append(where, `incident_type = ? COLLATE NOCASE`)
+		args = append(args, query.Type)
+	}
+	if query.Status != "" {
+		where = append(where, `status = ? COLLATE NOCASE`)
+		args = append(args, query.Status)
+	}
+	if query.Unit != 0 {
+		where = append(where, `calls.id IN (SELECT call_id FROM call_units WHERE src = ?)`)
+		args = append(args, query.Unit)
+	}
+	if !query.From.IsZero() {
+		where = append(where, `start_time >= ?`)
+		args = append(args, query.From.Unix())
+	}
+	if !query.To.IsZero() {
+		where = append(where, `start_time < ?`)
+		args = append(args, query.To.Unix())
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(where, " AND "), args
+}
+
+// limit returns the page size, applying the default and maximum
+func (query SearchQuery) limit() int {
+	switch {
+	case query.Limit <= 0:
+		return defaultSearchLimit
+	case query.Limit > maxSearchLimit:
+		return maxSearchLimit
+	}
+	return query.Limit
+}
+
+// Search returns the page of calls matching query, newest first
+func (a *Archive) Search(ctx context.Context, query SearchQuery) (SearchResults, error) {
+	filter, args := query.where()
+	results := SearchResults{Results: []ArchivedCall{}}
+	if err := a.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM calls `+filter, args...).Scan(&results.Total); err != nil {
+		return results, err
+	}
+
+	// highlight matches in the snippet only when searching text
+	snippet := `''`
+	if text := ftsQuery(query.Text); text != "" {
+		snippet = `(SELECT snippet(calls_fts, 0, '[', ']', '…', 16) FROM calls_fts WHERE calls_fts MATCH ? AND rowid = calls.id)`
+		args = append([]any{text}, args...)
+	}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT key, text, metadata, `+snippet+`
+		FROM calls `+filter+`
+		ORDER BY start_time DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, query.limit(), query.Offset)...)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var call ArchivedCall
+		var b []byte
+		if err := rows.Scan(&call.Key, &call.Text, &b, &call.Snippet); err != nil {
+			return results, err
+		}
+		if err := json.Unmarshal(b, &call.Meta); err != nil {
+			return results, err
+		}
+		call.fill()
+		results.Results = append(results.Results, call)
+	}
+	if err := rows.Err(); err != nil {
+		return results, err
+	}
+
+	if next := query.Offset + len(results.Results); int64(next) < results.Total {
+		results.NextOffset = next
+	}
+	return results, nil
+}
+
+// TalkgroupSummary describes the archived calls of a talkgroup
+type TalkgroupSummary struct {
+	Talkgroup    int64
+	TalkgroupTag string
+	System       string
+	Agency       string
+	Calls        int64
+	LastCall     time.Time
+}
+
+// Talkgroups summarizes the talkgroups with calls matching query, most recently active first.
+// The query's Limit and Offset are ignored.
+func (a *Archive) Talkgroups(ctx context.Context, query SearchQuery) ([]TalkgroupSummary, error) {
+	filter, args := query.where()
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT talkgroup, MAX(talkgroup_tag), MAX(system), MAX(agency), COUNT(*), MAX(start_time)
+		FROM calls `+filter+`
+		GROUP BY talkgroup
+		ORDER BY MAX(start_time) DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var talkgroups []TalkgroupSummary
+	for rows.Next() {
+		var tg TalkgroupSummary
+		var last int64
+		if err := rows.Scan(&tg.Talkgroup, &tg.TalkgroupTag, &tg.System, &tg.Agency, &tg.Calls, &last); err != nil {
+			return nil, err
+		}
+		tg.LastCall = time.Unix(last, 0).In(location)
+		talkgroups = append(talkgroups, tg)
+	}
+	return talkgroups, rows.Err()
+}
+
+// Agencies returns the distinct agencies (talkgroup_group) in the archive
+func (a *Archive) Agencies(ctx context.Context) ([]string, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT DISTINCT agency FROM calls WHERE agency != '' ORDER BY agency`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agencies []string
+	for rows.Next() {
+		var agency string
+		if err := rows.Scan(&agency); err != nil {
+			return nil, err
+		}
+		agencies = append(agencies, agency)
+	}
+	return agencies, rows.Err()
+}
+
+// fill sets the call's fields from its metadata
+func (c *ArchivedCall) fill() {
+	meta := c.Meta
+	c.URL = "/audio?link=" + url.QueryEscape(c.Key)
+	c.StartTime = time.Unix(meta.StartTime, 0).In(location)
+	c.CallLength = meta.CallLength
+	c.Talkgroup = meta.Talkgroup
+	c.TalkgroupTag = meta.TalkgroupTag
+	c.System = meta.ShortName
+	c.Emergency = meta.IsEmergency()
+	for _, src := range meta.SrcList {
+		c.Units = append(c.Units, src.Src)
+	}
+	if details := callDetails(*meta); !details.IsZero() {
+		c.Incident = &details
+	}
+}
+
+// ftsQuery quotes each word of text so punctuation in a user's search is not parsed as fts5 query
+// syntax. Every word must match. Text of only whitespace is no query.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// archiveCall indexes the call, logging rather than failing the request if it can't
+func archiveCall(ctx context.Context, config *Config, key string, meta Metadata) {
+	if config == nil || config.archive == nil {
+		return
+	}
+	if err := config.archive.Add(context.WithoutCancel(ctx), key, meta); err != nil {
+		log.Printf("[archive] Error archiving %s: %v", key, err)
+	}
+}
+
+// parseSearchQuery reads a SearchQuery from the /api/search query string. Times may be RFC 3339
+// or unix seconds.
+func parseSearchQuery(values url.Values) (query SearchQuery, err error) {
+	query.Text = strings.TrimSpace(values.Get("q"))
+	query.System = values.Get("system")
+	query.Agency = values.Get("agency")
+	query.Type = values.Get("type")
+	query.Status = values.Get("status")
+	query.Emergency, _ = strconv.ParseBool(values.Get("emergency"))
+
+	ints := []struct {
+		name string
+		dest *int64
+	}{
+		{"talkgroup", &query.Talkgroup},
+		{"unit", &query.Unit},
+	}
+	var errs []error
+	for _, param := range ints {
+		if v := values.Get(param.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, errors.New("invalid "+param.name+": "+v))
+			}
+			*param.dest = n
+		}
+	}
+
+	for name, dest := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				errs = append(errs, errors.New("invalid "+name+": "+v))
+			}
+			*dest = n
+		}
+	}
+
+	for name, dest := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if v := values.Get(name); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				errs = append(errs, errors.New("invalid "+name+": "+v))
+			}
+			*dest = t
+		}
+	}
+	return query, errors.Join(errs...)
+}
+
+func parseTime(v string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// handleSearch serves GET /api/search
+func handleSearch(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseSearchQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results, err := config.archive.Search(r.Context(), query)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
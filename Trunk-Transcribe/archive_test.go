@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testArchive returns an archive holding the sample call and two later calls
+func testArchive(t *testing.T) *Archive {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	archive, err := NewArchive(ctx, db)
+	require.NoError(t, err)
+
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+	meta.AudioText = "Berkeley, 2605 Durant. Copy."
+	meta.Segments = []Segment{{Start: 0, End: 2.8, Text: "Berkeley, 2605 Durant."}, {Start: 2.9, End: 4.2, Text: "Copy."}}
+	require.NoError(t, archive.Add(ctx, filename, meta))
+
+	bike := meta
+	bike.StartTime += 60
+	bike.AudioText = "Bike versus auto at Shattuck and Ward."
+	bike.Segments = nil
+	bike.SrcList = []Source{{Src: 3124120, Pos: 0}}
+	require.NoError(t, archive.Add(ctx, "Berkeley/3105/bike.wav", bike))
+
+	oakland := meta
+	oakland.StartTime += 120
+	oakland.Talkgroup = 3405
+	oakland.ShortName = "Oakland"
+	oakland.TalkGroupGroup = "Oakland"
+	oakland.AudioText = "Oakland, bike on the freeway."
+	oakland.Segments = nil
+	require.NoError(t, archive.Add(ctx, "Oakland/3405/bike.wav", oakland))
+
+	return archive
+}
+
+func TestArchiveSearch(t *testing.T) {
+	archive := testArchive(t)
+	start := time.Unix(1702617247, 0)
+
+	tests := []struct {
+		name   string
+		query  SearchQuery
+		expect []string
+		total  int64
+		next   int
+	}{
+		{
+			name:   "everything newest first",
+			query:  SearchQuery{},
+			expect: []string{"Oakland/3405/bike.wav", "Berkeley/3105/bike.wav", filename},
+			total:  3,
+		},
+		{
+			name:   "text",
+			query:  SearchQuery{Text: "bike"},
+			expect: []string{"Oakland/3405/bike.wav", "Berkeley/3105/bike.wav"},
+			total:  2,
+		},
+		{
+			name:   "text with punctuation",
+			query:  SearchQuery{Text: `"durant."`},
+			expect: []string{filename},
+			total:  1,
+		},
+		{
+			name:   "text and talkgroup",
+			query:  SearchQuery{Text: "bike", Talkgroup: 3105},
+			expect: []string{"Berkeley/3105/bike.wav"},
+			total:  1,
+		},
+		{
+			name:   "system",
+			query:  SearchQuery{System: "oakland"},
+			expect: []string{"Oakland/3405/bike.wav"},
+			total:  1,
+		},
+		{
+			name:   "incident type",
+			query:  SearchQuery{Type: "bicycle vs vehicle"},
+			expect: []string{"Berkeley/3105/bike.wav"},
+			total:  1,
+		},
+		{
+			name:   "unit",
+			query:  SearchQuery{Unit: 3113008},
+			expect: []string{"Oakland/3405/bike.wav", filename},
+			total:  2,
+		},
+		{
+			name:   "time range",
+			query:  SearchQuery{From: start.Add(time.Minute), To: start.Add(2 * time.Minute)},
+			expect: []string{"Berkeley/3105/bike.wav"},
+			total:  1,
+		},
+		{
+			name:   "whitespace text",
+			query:  SearchQuery{Text: "   "},
+			expect: []string{"Oakland/3405/bike.wav", "Berkeley/3105/bike.wav", filename},
+			total:  3,
+		},
+		{
+			name:   "first page",
+			query:  SearchQuery{Limit: 2},
+			expect: []string{"Oakland/3405/bike.wav", "Berkeley/3105/bike.wav"},
+			total:  3,
+			next:   2,
+		},
+		{
+			name:   "last page",
+			query:  SearchQuery{Limit: 2, Offset: 2},
+			expect: []string{filename},
+			total:  3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := archive.Search(context.Background(), test.query)
+			require.NoError(t, err)
+
+			keys := []string{}
+			for _, call := range results.Results {
+				keys = append(keys, call.Key)
+			}
+			assert.Equal(t, test.expect, keys)
+			assert.Equal(t, test.total, results.Total)
+			assert.Equal(t, test.next, results.NextOffset)
+		})
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	assert.Equal(t, defaultSearchLimit, SearchQuery{}.limit())
+	assert.Equal(t, 20, SearchQuery{Limit: 20}.limit())
+	assert.Equal(t, maxSearchLimit, SearchQuery{Limit: 1000}.limit())
+}
+
+func TestSearchAPI(t *testing.T) {
+	mux := mux(&Config{archive: testArchive(t)}, nil)
+
+	r, _ := http.NewRequest("GET", "/api/search?q=durant&talkgroup=3105&from=2023-12-15T00:00:00Z", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var results SearchResults
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	require.Len(t, results.Results, 1)
+	call := results.Results[0]
+	assert.Equal(t, "/audio?link="+url.QueryEscape(filename), call.URL)
+	assert.Equal(t, "Berkeley, 2605 [Durant]. Copy.", call.Snippet)
+	assert.Equal(t, []int64{3124119, 3113008}, call.Units)
+	assert.Nil(t, call.Incident)
+
+	r, _ = http.NewRequest("GET", "/api/search?q=%20%20", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	r, _ = http.NewRequest("GET", "/api/search?talkgroup=pd", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -5,24 +5,20 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/google/generative-ai-go/genai"
-	ffmpeg "github.com/u2takey/ffmpeg-go"
 	"google.golang.org/api/option"
 )
 
-const (
-	deepFilterCmd      = "./deep-filter"
-	silenceThreshold   = 100                    // Adjust as needed
-	minSilenceDuration = 200 * time.Millisecond // Minimum duration of silence to remove
-)
+const deepFilterCmd = "./deep-filter"
 
 // google gemini
 var geminiApiKey string = os.Getenv("GEMINI_API_KEY")
@@ -32,72 +28,107 @@ var cloudflareApiToken string = os.Getenv("CLOUDFLARE_API_TOKEN")
 var cloudflareAccountID string = os.Getenv("CLOUDFLARE_ACCOUNT_ID")
 var cloudflareWhisperUrl string = "https://api.cloudflare.com/client/v4/accounts/" + cloudflareAccountID + "/ai/run/@cf/openai/whisper-large-v3-turbo"
 
+// local whisper.cpp/faster-whisper command line, e.g. "whisper-cli -m /models/ggml-base.en.bin -oj -of {output} -f {input}"
+var localWhisperCommand, localWhisperArgs = splitCommand(os.Getenv("LOCAL_WHISPER_CMD"))
+
 // Utility audio functions including silence removal, enhancement transcription
 
-// whisper transcribes the audio with cloudflare Whisper
-func whisper(ctx context.Context, data []byte) (msg string, segments []string, err error) {
-	prompt := strings.Join(append(streets, append(modifiers, terms...)...), ", ")
+// transcriptionPrompt lists local street names and radio terms to steer transcription
+func transcriptionPrompt() string {
+	return strings.Join(append(gazetteer.Streets("Berkeley"), append(modifiers, terms...)...), ", ")
+}
 
+// CloudflareWhisper transcribes audio with Whisper on Cloudflare Workers AI
+type CloudflareWhisper struct {
+	URL   string
+	Token string
+}
+
+func (c *CloudflareWhisper) Name() string { return "cloudflare" }
+
+// Transcribe transcribes the audio with cloudflare Whisper
+func (c *CloudflareWhisper) Transcribe(ctx context.Context, data []byte) (Transcription, error) {
 	enc := base64.StdEncoding.EncodeToString(data)
 	payload, err := json.Marshal(CloudflareWhisperInput{
 		Audio:  enc,
-		Prompt: prompt,
+		Prompt: transcriptionPrompt(),
 	})
 
 	if err != nil {
-		return "", nil, err
+		return Transcription{}, err
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", cloudflareWhisperUrl, bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL, bytes.NewReader(payload))
 	if err != nil {
-		return "", nil, err
+		return Transcription{}, err
 	}
-	req.Header.Set("Authorization", "Bearer "+cloudflareApiToken)
+	req.Header.Set("Authorization", "Bearer "+c.Token)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		fmt.Printf("Error calling cloudflare: %v\n", err)
-		return "", nil, err
+		return Transcription{}, err
 	}
 	defer resp.Body.Close()
-	var output CloudflareWhisperOutput
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", nil, err
+		return Transcription{}, err
 	}
+	if resp.StatusCode > 299 {
+		return Transcription{}, newHTTPStatusError(resp, body)
+	}
+
+	var output CloudflareWhisperOutput
 	err = json.Unmarshal(body, &output)
 	if err != nil {
-		return "", nil, err
+		return Transcription{}, err
+	}
+	if !output.Success {
+		return Transcription{}, fmt.Errorf("cloudflare whisper failed: %v", output.Errors)
 	}
 
-	msg = output.Result.Text
 	//fmt.Println("Response from cloudflare: ", string(body))
-	for _, segment := range output.Result.Segments {
-		segments = append(segments, segment.Text)
-	}
+	return Transcription{
+		Text:     output.Result.Text,
+		Segments: output.Result.Segments,
+	}, nil
+}
 
-	return msg, segments, nil
+// Gemini transcribes audio with Google Gemini. It returns the text as a single untimed segment.
+type Gemini struct {
+	APIKey   string
+	Model    string
+	Endpoint string // overrides the default api endpoint when set
 }
 
-func gemini(ctx context.Context, data []byte) (string, error) {
+func (g *Gemini) Name() string { return "gemini" }
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(geminiApiKey))
+func (g *Gemini) Transcribe(ctx context.Context, data []byte) (Transcription, error) {
+	opts := []option.ClientOption{option.WithAPIKey(g.APIKey)}
+	if g.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(g.Endpoint))
+	}
+	client, err := genai.NewClient(ctx, opts...)
 	if err != nil {
-		return "", err
+		return Transcription{}, err
 	}
 	defer client.Close()
 
-	prompt := strings.Join(append(streets, append(modifiers, terms...)...), ", ")
+	mimeType := http.DetectContentType(data)
+	if mimeType == "audio/wave" {
+		mimeType = "audio/wav"
+	}
+
 	parts := []genai.Part{
-		genai.Blob{MIMEType: "audio/mp3", Data: data},
+		genai.Blob{MIMEType: mimeType, Data: data},
 		genai.Text("Please transcribe the audio. "),
 		genai.Text("Ignore silences."),
-		genai.Text("Here are some correction terms: " + prompt),
+		genai.Text("Here are some correction terms: " + transcriptionPrompt()),
 	}
 
-	model := client.GenerativeModel("gemini-1.5-pro")
+	model := client.GenerativeModel(g.Model)
 	resp, err := model.GenerateContent(ctx, parts...)
 	if err != nil {
-		return "", err
+		return Transcription{}, err
 	}
 
 	var transcriptionParts []string
@@ -115,7 +146,111 @@ func gemini(ctx context.Context, data []byte) (string, error) {
 	}
 
 	msg := strings.Join(transcriptionParts, "\n")
-	return msg, nil
+	transcription := Transcription{Text: msg}
+	if msg != "" {
+		transcription.Segments = []Segment{{Text: msg}}
+	}
+	return transcription, nil
+}
+
+// LocalWhisper transcribes audio by running a local whisper.cpp or faster-whisper binary. The
+// audio is written to a temp file substituted for {input} in Args. {output} is substituted with a
+// path prefix: if the command writes {output}.json (e.g. whisper.cpp -oj -of {output}) it is read,
+// otherwise stdout is parsed. Both whisper.cpp json and openai style verbose json are understood.
+type LocalWhisper struct {
+	Command string
+	Args    []string
+}
+
+func (l *LocalWhisper) Name() string { return "local" }
+
+func (l *LocalWhisper) Transcribe(ctx context.Context, data []byte) (Transcription, error) {
+	if l.Command == "" {
+		return Transcription{}, errors.New("local whisper command not configured")
+	}
+
+	dir, err := os.MkdirTemp("", "whisper")
+	if err != nil {
+		return Transcription{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "audio.wav")
+	output := filepath.Join(dir, "transcript")
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return Transcription{}, err
+	}
+
+	args := make([]string, len(l.Args))
+	for i, arg := range l.Args {
+		arg = strings.ReplaceAll(arg, "{input}", input)
+		args[i] = strings.ReplaceAll(arg, "{output}", output)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, l.Command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Transcription{}, fmt.Errorf("%s: %w: %s", l.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	out, err := os.ReadFile(output + ".json")
+	if err != nil {
+		out = stdout.Bytes()
+	}
+	return parseLocalWhisperOutput(out)
+}
+
+// whisperCppOutput is the json written by whisper.cpp with -oj
+type whisperCppOutput struct {
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"` // milliseconds
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+}
+
+// splitCommand splits a space separated command line into the command and its arguments
+func splitCommand(cmdline string) (string, []string) {
+	fields := strings.Fields(cmdline)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], fields[1:]
+}
+
+func parseLocalWhisperOutput(out []byte) (Transcription, error) {
+	var cpp whisperCppOutput
+	if err := json.Unmarshal(out, &cpp); err == nil && len(cpp.Transcription) > 0 {
+		var transcription Transcription
+		var text []string
+		for _, t := range cpp.Transcription {
+			transcription.Segments = append(transcription.Segments, Segment{
+				Start: float32(t.Offsets.From) / 1000,
+				End:   float32(t.Offsets.To) / 1000,
+				Text:  t.Text,
+			})
+			text = append(text, strings.TrimSpace(t.Text))
+		}
+		transcription.Text = strings.Join(text, " ")
+		return transcription, nil
+	}
+
+	var info TranscriptionInfo
+	if err := json.Unmarshal(out, &info); err == nil {
+		return Transcription{Text: info.Text, Segments: info.Segments}, nil
+	}
+
+	// plain text output
+	text := strings.TrimSpace(string(out))
+	transcription := Transcription{Text: text}
+	if text != "" {
+		transcription.Segments = []Segment{{Text: text}}
+	}
+	return transcription, nil
 }
 
 // deepFilter runs an audio enhancement framework on the data based on the Deepfilter ai model
@@ -141,35 +276,3 @@ func deepFilter(ctx context.Context, data []byte) ([]byte, error) {
 
 	return io.ReadAll(audioFile)
 }
-
-func removeSilence(ctx context.Context, data []byte) io.Reader {
-	reader, writer := io.Pipe()
-
-	go func() {
-		stream := ffmpeg.Input("pipe:")
-
-		stream.Context = ctx
-
-		err := stream.
-			WithInput(bytes.NewBuffer(data)).
-			Output("pipe:", ffmpeg.KwArgs{
-				"af":          "silenceremove=1:0:-50dB",
-				"format":      "wav",
-				"hide_banner": "",
-				"loglevel":    "error",
-			}).
-			WithOutput(writer).
-			Silent(true).
-			ErrorToStdOut().
-			Run()
-
-		switch err {
-		case nil:
-			writer.Close()
-		default:
-			writer.CloseWithError(err)
-		}
-	}()
-
-	return reader
-}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// blob storage settings. BLOB_STORE is "r2" (the default), "s3" or "local".
+var blobStoreType string = getenv("BLOB_STORE", "r2")
+var blobBucket string = getenv("BLOB_BUCKET", "scanner-berkeley")
+var blobPrefix string = os.Getenv("BLOB_PREFIX")
+var blobPublicURL string = os.Getenv("BLOB_PUBLIC_URL")
+var blobEndpoint string = os.Getenv("BLOB_ENDPOINT") // s3 only, e.g. a MinIO server
+var blobDir string = getenv("BLOB_DIR", filepath.Join(dataDir, "audio"))
+
+// the public url of the R2 bucket
+var r2Path string = "https://pub-85c4b9a9667540e99c0109c068c47e0f.r2.dev"
+
+// localFilesPath is where the local store's files are served from
+const localFilesPath = "/audio/files/"
+
+// BlobStore stores call audio and transcript sidecars
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string, meta map[string]string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List calls fn with the key of every object under prefix
+	List(ctx context.Context, prefix string, fn func(key string) error) error
+	// URL returns the public url of the object, for playback
+	URL(key string) (string, error)
+}
+
+// newBlobStore creates the blob store selected by BLOB_STORE
+func newBlobStore() (BlobStore, error) {
+	switch blobStoreType {
+	case "r2":
+		endpoint := fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cloudflareAccountID)
+		fmt.Println("Using cloudflare R2 endpoint: ", endpoint)
+		sess, err := session.NewSession(&aws.Config{
+			Region:      aws.String("auto"),
+			Credentials: credentials.NewStaticCredentials(r2Key, r2Secret, ""),
+			Endpoint:    aws.String(endpoint),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(sess, blobBucket, blobPrefix, cmp.Or(blobPublicURL, r2Path)), nil
+	case "s3":
+		// credentials and region come from the usual AWS environment variables
+		config := &aws.Config{S3ForcePathStyle: aws.Bool(blobEndpoint != "")}
+		if blobEndpoint != "" {
+			config.Endpoint = aws.String(blobEndpoint)
+		}
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(sess, blobBucket, blobPrefix, blobPublicURL), nil
+	case "local":
+		return NewLocalStore(filepath.Join(blobDir, blobPrefix), cmp.Or(blobPublicURL, localFilesPath))
+	}
+	return nil, fmt.Errorf("unknown BLOB_STORE %q", blobStoreType)
+}
+
+// S3Store stores blobs in an S3 compatible bucket such as Cloudflare R2, under an optional prefix
+type S3Store struct {
+	client    s3iface.S3API
+	uploader  *s3manager.Uploader
+	bucket    string
+	prefix    string
+	publicURL string
+}
+
+func NewS3Store(sess *session.Session, bucket, prefix, publicURL string) *S3Store {
+	return &S3Store{
+		client:    s3.New(sess),
+		uploader:  s3manager.NewUploader(sess),
+		bucket:    bucket,
+		prefix:    prefix,
+		publicURL: publicURL,
+	}
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, contentType string, meta map[string]string) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.prefix + key),
+		Body:        body,
+		Metadata:    aws.StringMap(meta),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return object.Body, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string, fn func(key string) error) error {
+	var fnErr error
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if fnErr = fn(strings.TrimPrefix(aws.StringValue(object.Key), s.prefix)); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	return errors.Join(err, fnErr)
+}
+
+func (s *S3Store) URL(key string) (string, error) {
+	if s.publicURL == "" {
+		return "", errors.New("no public url configured for bucket " + s.bucket)
+	}
+	return url.JoinPath(s.publicURL, s.prefix+key)
+}
+
+// LocalStore stores blobs as files under a directory, for running without a cloud account. The
+// files are served by the /audio/files/ endpoint.
+type LocalStore struct {
+	dir       string
+	publicURL string
+}
+
+func NewLocalStore(dir, publicURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{dir: dir, publicURL: publicURL}, nil
+}
+
+// path returns the file holding key, rejecting keys that would escape the store's directory
+func (s *LocalStore) path(key string) (string, error) {
+	if !fs.ValidPath(key) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the file atomically. Content type and metadata are not stored; content types are
+// inferred from the file extension when serving.
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, contentType string, meta map[string]string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string, fn func(key string) error) error {
+	return filepath.WalkDir(s.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(path.Base(key), ".tmp-") {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(key)
+	})
+}
+
+func (s *LocalStore) URL(key string) (string, error) {
+	return url.JoinPath(s.publicURL, key)
+}
+
+// ServeHTTP serves the stored files below localFilesPath
+func (s *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, err := s.path(strings.TrimPrefix(r.URL.Path, localFilesPath))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if info, err := os.Stat(name); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, name)
+}
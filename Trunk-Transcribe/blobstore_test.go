@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an in-process stand-in for the parts of the S3 api we use: put, get and list objects
+// in path style buckets
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte // bucket/key to content
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		f.objects[bucket+"/"+key] = b
+	case r.Method == http.MethodGet && key != "":
+		b, ok := f.objects[bucket+"/"+key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	case r.Method == http.MethodGet:
+		type content struct {
+			Key  string
+			Size int
+		}
+		var list struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			IsTruncated bool
+			Contents    []content
+		}
+		list.Name = bucket
+		for name, b := range f.objects {
+			if key, ok := strings.CutPrefix(name, bucket+"/"); ok && strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				list.Contents = append(list.Contents, content{Key: key, Size: len(b)})
+			}
+		}
+		slices.SortFunc(list.Contents, func(a, b content) int { return strings.Compare(a.Key, b.Key) })
+		xml.NewEncoder(w).Encode(list)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+// fakeS3Store returns an S3Store backed by a fakeS3 server
+func fakeS3Store(t *testing.T, prefix string) (*S3Store, *fakeS3) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("auto"),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	require.NoError(t, err)
+	return NewS3Store(sess, blobBucket, prefix, "https://pub.example.com"), fake
+}
+
+func TestBlobStores(t *testing.T) {
+	s3Store, _ := fakeS3Store(t, "calls/")
+	localStore, err := NewLocalStore(t.TempDir(), localFilesPath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		store BlobStore
+		url   string
+	}{
+		{
+			name:  "s3",
+			store: s3Store,
+			url:   "https://pub.example.com/calls/" + filename,
+		},
+		{
+			name:  "local",
+			store: localStore,
+			url:   localFilesPath + filename,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			require.NoError(t, test.store.Put(ctx, filename, bytes.NewReader([]byte("RIFF")), "audio/wav", map[string]string{"talk-group": "2105"}))
+			require.NoError(t, test.store.Put(ctx, "Oakland/3405/call.wav", bytes.NewReader([]byte("RIFF")), "audio/wav", nil))
+
+			body, err := test.store.Get(ctx, filename)
+			require.NoError(t, err)
+			b, _ := io.ReadAll(body)
+			body.Close()
+			assert.Equal(t, "RIFF", string(b))
+
+			var keys []string
+			require.NoError(t, test.store.List(ctx, "Berkeley/", func(key string) error {
+				keys = append(keys, key)
+				return nil
+			}))
+			assert.Equal(t, []string{filename}, keys)
+
+			url, err := test.store.URL(filename)
+			require.NoError(t, err)
+			assert.Equal(t, test.url, url)
+		})
+	}
+}
+
+func TestLocalAudio(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), localFilesPath)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(context.Background(), filename, bytes.NewReader([]byte("RIFF")), "audio/wav", nil))
+
+	_, err = store.Get(context.Background(), "../"+filename)
+	assert.ErrorContains(t, err, "invalid key")
+
+	mux := mux(&Config{store: store}, nil)
+
+	tests := []struct {
+		url      string
+		code     int
+		contains string
+	}{
+		{url: "/audio?link=" + filename, code: http.StatusOK, contains: `src="` + localFilesPath + filename + `"`},
+		{url: localFilesPath + filename, code: http.StatusOK, contains: "RIFF"},
+		{url: localFilesPath + "Berkeley/2105", code: http.StatusNotFound},
+		{url: "/audio/files/Oakland/missing.wav", code: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", test.url, nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, r)
+			assert.Equal(t, test.code, rr.Code)
+			assert.Contains(t, rr.Body.String(), test.contains)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// dateLayout is the format of the date filter in the call browser
+const dateLayout = "2006-01-02"
+
+// browserFilters are the filters shared by the call browser pages
+type browserFilters struct {
+	Query    SearchQuery
+	Date     string
+	Agencies []string
+}
+
+// talkgroupsPage lists the talkgroups with archived calls
+type talkgroupsPage struct {
+	browserFilters
+	Talkgroups []TalkgroupSummary
+}
+
+// timelinePage lists a talkgroup's calls, newest first
+type timelinePage struct {
+	browserFilters
+	Talkgroup    int64
+	TalkgroupTag string
+	Total        int64
+	Calls        []callView
+	Prev         string
+	Next         string
+}
+
+// callView is an archived call as rendered in the call browser
+type callView struct {
+	ArchivedCall
+	Audio      string
+	Transcript []string
+}
+
+// parseBrowserFilters reads the filters from the query string. A date selects the calls from that
+// day in Berkeley.
+func parseBrowserFilters(r *http.Request, config *Config) (filters browserFilters, err error) {
+	values := r.URL.Query()
+	filters.Query, err = parseSearchQuery(values)
+	if err != nil {
+		return filters, err
+	}
+
+	if filters.Date = values.Get("date"); filters.Date != "" {
+		day, err := time.ParseInLocation(dateLayout, filters.Date, location)
+		if err != nil {
+			return filters, errors.New("invalid date: " + filters.Date)
+		}
+		filters.Query.From = day
+		filters.Query.To = day.AddDate(0, 0, 1)
+	}
+
+	filters.Agencies, err = config.archive.Agencies(r.Context())
+	return filters, err
+}
+
+// handleTalkgroups serves GET /calls, the talkgroups with calls matching the filters
+func handleTalkgroups(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filters, err := parseBrowserFilters(r, config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		talkgroups, err := config.archive.Talkgroups(r.Context(), filters.Query)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		t.ExecuteTemplate(w, "calls.html.tmpl", talkgroupsPage{browserFilters: filters, Talkgroups: talkgroups})
+	}
+}
+
+// handleTimeline serves GET /calls/{talkgroup}, the talkgroup's calls matching the filters with
+// their transcripts and audio
+func handleTimeline(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		talkgroup, err := strconv.ParseInt(r.PathValue("talkgroup"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid talkgroup", http.StatusBadRequest)
+			return
+		}
+		filters, err := parseBrowserFilters(r, config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filters.Query.Talkgroup = talkgroup
+
+		results, err := config.archive.Search(r.Context(), filters.Query)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+
+		page := timelinePage{browserFilters: filters, Talkgroup: talkgroup, Total: results.Total}
+		for _, call := range results.Results {
+			page.TalkgroupTag = call.TalkgroupTag
+			audio, err := audioURL(config, call.Key)
+			if err != nil {
+				writeErr(w, err)
+				return
+			}
+			transcript := speakerTranscript(*call.Meta)
+			if len(transcript) == 0 && call.Meta.AudioText != "" {
+				transcript = []string{call.Meta.AudioText}
+			}
+			page.Calls = append(page.Calls, callView{ArchivedCall: call, Audio: audio, Transcript: transcript})
+		}
+
+		if filters.Query.Offset > 0 {
+			page.Prev = pageURL(r.URL, max(filters.Query.Offset-filters.Query.limit(), 0))
+		}
+		if results.NextOffset > 0 {
+			page.Next = pageURL(r.URL, results.NextOffset)
+		}
+		t.ExecuteTemplate(w, "timeline.html.tmpl", page)
+	}
+}
+
+// pageURL returns u with its offset replaced
+func pageURL(u *url.URL, offset int) string {
+	values := u.Query()
+	values.Set("offset", strconv.Itoa(offset))
+	return u.Path + "?" + values.Encode()
+}
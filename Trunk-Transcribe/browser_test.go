@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallBrowser(t *testing.T) {
+	mux := mux(&Config{archive: testArchive(t)}, nil)
+
+	tests := []struct {
+		name     string
+		url      string
+		code     int
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "talkgroups",
+			url:      "/calls",
+			code:     http.StatusOK,
+			contains: []string{"Berkeley PD1", `href="/calls/3105?`, `href="/calls/3405?`, `<option value="Berkeley"`},
+		},
+		{
+			name:     "agency filter",
+			url:      "/calls?agency=berkeley&q=oakland",
+			code:     http.StatusOK,
+			contains: []string{"No calls found"},
+		},
+		{
+			name: "timeline",
+			url:  "/calls/3105",
+			code: http.StatusOK,
+			contains: []string{
+				"3124119: Berkeley, 2605 Durant.", "Dispatch: Copy.",
+				"Bike versus auto at Shattuck and Ward.",
+				`src="https://pub-85c4b9a9667540e99c0109c068c47e0f.r2.dev/` + filename + `"`,
+			},
+			excludes: []string{"Oakland, bike on the freeway.", "EMERGENCY"},
+		},
+		{
+			name:     "timeline by day",
+			url:      "/calls/3105?date=2023-12-14",
+			code:     http.StatusOK,
+			contains: []string{"2 calls"},
+		},
+		{
+			name:     "timeline pages",
+			url:      "/calls/3105?limit=1",
+			code:     http.StatusOK,
+			contains: []string{"Bike versus auto", `href="/calls/3105?limit=1&amp;offset=1"`},
+			excludes: []string{"2605 Durant", "Newer"},
+		},
+		{
+			name:     "emergency filter",
+			url:      "/calls/3105?emergency=true",
+			code:     http.StatusOK,
+			contains: []string{"No calls found"},
+		},
+		{
+			name: "invalid date",
+			url:  "/calls?date=yesterday",
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", test.url, nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, r)
+			require.Equal(t, test.code, rr.Code, rr.Body.String())
+
+			for _, s := range test.contains {
+				assert.Contains(t, rr.Body.String(), s)
+			}
+			for _, s := range test.excludes {
+				assert.NotContains(t, rr.Body.String(), s)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ type Call struct {
 	AudioName      string    `json:"audioName"`
 	AudioType      string    `json:"audioType"`
 	DateTime       time.Time `json:"dateTime"`
+	Emergency      bool      `json:"emergency"`
 	Frequencies    any       `json:"frequencies"`
 	Frequency      int64     `json:"frequency"`
 	Patches        any       `json:"patches"`
@@ -129,6 +130,38 @@ func (call *Call) ToMetadata() (Metadata, error) {
 	if err != nil {
 		return metadata, err
 	}
+	if call.Emergency {
+		metadata.Emergency = 1
+	}
+
+	// rdio frequencies carry the same decode counts as trunk-recorder's freqList
+	var frequencies []struct {
+		Freq       int64   `json:"freq"`
+		Pos        float64 `json:"pos"`
+		Len        float64 `json:"len"`
+		ErrorCount Count   `json:"errorCount"`
+		SpikeCount Count   `json:"spikeCount"`
+	}
+	if call.Frequencies != nil {
+		b, err = json.Marshal(call.Frequencies)
+		if err != nil {
+			return metadata, err
+		}
+		err = json.Unmarshal(b, &frequencies)
+		if err != nil {
+			return metadata, err
+		}
+	}
+	for _, f := range frequencies {
+		metadata.FreqList = append(metadata.FreqList, Frequency{
+			Freq:       f.Freq,
+			Time:       call.DateTime.Unix() + int64(f.Pos),
+			Pos:        f.Pos,
+			Len:        f.Len,
+			ErrorCount: f.ErrorCount,
+			SpikeCount: f.SpikeCount,
+		})
+	}
 
 	return metadata, nil
 }
@@ -167,6 +200,11 @@ func (call *Call) ParseMultipartContent(p *multipart.Part, b []byte) {
 			call.DateTime = call.DateTime.UTC()
 		}
 
+	case "emergency":
+		if ok, err := strconv.ParseBool(string(b)); err == nil {
+			call.Emergency = ok
+		}
+
 	case "frequencies":
 		var f any
 		if err := json.Unmarshal(b, &f); err == nil {
@@ -263,6 +301,16 @@ func (call *Call) ParseMultipartContent(p *multipart.Part, b []byte) {
 								src["pos"] = uint(v)
 							}
 						}
+						switch e := v["emergency"].(type) {
+						case float64:
+							if e > 0 {
+								src["emergency"] = 1
+							}
+						case bool:
+							if e {
+								src["emergency"] = 1
+							}
+						}
 						switch s := v["src"].(type) {
 						case float64:
 							if s > 0 {
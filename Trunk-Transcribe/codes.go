@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// codesConfigPath optionally points at a code table that replaces the embedded default
+var codesConfigPath string = os.Getenv("CODES_CONFIG")
+
+//go:embed config/codes.json
+var defaultCodesConfig []byte
+
+var codeTable = mustParseCodeTable(defaultCodesConfig)
+
+// collisionRegex matches the parties of a collision next to each other, e.g. "auto vs. ped"
+var collisionRegex = regexp.MustCompile(`\b` + modeString + `\s+(?:vs|versus|verses)\.?\s+` + modeString + `\b`)
+
+// the parties of a collision as transcribed, e.g. "auto vs. ped", by their incident type name
+var collisionModes = map[string]string{
+	"auto": "vehicle", "car": "vehicle", "driver": "vehicle", "vehicle": "vehicle",
+	"bike": "bicycle", "bicycle": "bicycle", "cyclist": "bicycle", "bicyclist": "bicycle",
+	"pedestrian": "pedestrian", "ped": "pedestrian",
+	"pavement": "pavement",
+}
+
+// Structures to parse the code table json of the form:
+//
+//	{
+//	  "codes": [
+//	    {"code": "211", "meaning": "Robbery", "kind": "penal"},
+//	    {"code": "10-97", "meaning": "Arrived on scene", "status": "on scene"},
+//	    {"code": "code 3", "aliases": ["code three"], "meaning": "Lights and siren"}
+//	  ],
+//	  "statuses": {"en route": "en route", "arrived": "on scene"},
+//	  "units": ["Engine", "Medic"]
+//	}
+//
+// A penal code names the incident type. Codes and status phrases with a status set the status of
+// the units, the last one heard wins. Units are the call signs dispatch assigns by number, such as
+// "Engine 2". Radio codes of three digits must be transcribed with their hyphen, "10-4" rather
+// than "104", so unit and address numbers are not taken for codes.
+type CodeTableConfig struct {
+	Codes    []Code            `json:"codes"`
+	Statuses map[string]string `json:"statuses,omitempty"`
+	Units    []string          `json:"units,omitempty"`
+}
+
+type Code struct {
+	Code    string   `json:"code"`
+	Aliases []string `json:"aliases,omitempty"`
+	Meaning string   `json:"meaning"`
+	Kind    string   `json:"kind,omitempty"`
+	Status  string   `json:"status,omitempty"`
+}
+
+const codeKindPenal = "penal"
+
+// codePhrase is a code or status phrase as its transcribed words
+type codePhrase struct {
+	code       *Code
+	status     string
+	hyphenated bool // a short radio code that must be transcribed with its hyphen, as "104" is a number
+}
+
+// CodeTable turns the codes, units and statuses in a transcript into incident details
+type CodeTable struct {
+	phrases  map[string]codePhrase
+	maxWords int
+	units    map[string]string // lowercase call sign to its name
+}
+
+// CodeMeaning is a code heard in a call and what it means
+type CodeMeaning struct {
+	Code    string `json:"code"`
+	Meaning string `json:"meaning"`
+}
+
+// IncidentDetails are the structured fields extracted from a call's transcript
+type IncidentDetails struct {
+	Type   string        `json:"type,omitempty"` // e.g. "Bicycle vs vehicle" or "Robbery"
+	Codes  []CodeMeaning `json:"codes,omitempty"`
+	Units  []string      `json:"units,omitempty"` // e.g. "Engine 2"
+	Status string        `json:"status,omitempty"`
+}
+
+func mustParseCodeTable(b []byte) *CodeTable {
+	codes, err := ParseCodeTable(b)
+	if err != nil {
+		panic(err)
+	}
+	return codes
+}
+
+// ParseCodeTable parses code table json
+func ParseCodeTable(b []byte) (*CodeTable, error) {
+	var config CodeTableConfig
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	return NewCodeTable(config)
+}
+
+// LoadCodeTable reads the code table at path
+func LoadCodeTable(path string) (*CodeTable, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := ParseCodeTable(b)
+	if err != nil {
+		return nil, fmt.Errorf("code table %s: %w", path, err)
+	}
+	return codes, nil
+}
+
+func NewCodeTable(config CodeTableConfig) (*CodeTable, error) {
+	c := &CodeTable{phrases: map[string]codePhrase{}, units: map[string]string{}}
+	for i := range config.Codes {
+		code := &config.Codes[i]
+		if code.Code == "" || code.Meaning == "" {
+			return nil, fmt.Errorf("code %d: missing code or meaning", i)
+		}
+		for _, name := range append([]string{code.Code}, code.Aliases...) {
+			words := codeWords(name)
+			compact := strings.Join(words, " ")
+			c.add(words, codePhrase{
+				code:       code,
+				status:     code.Status,
+				hyphenated: strings.Contains(name, "-") && len(words) == 1 && len(compact) < 4,
+			})
+		}
+	}
+	for phrase, status := range config.Statuses {
+		c.add(codeWords(phrase), codePhrase{status: status})
+	}
+	for _, unit := range config.Units {
+		c.units[strings.ToLower(unit)] = unit
+	}
+	return c, nil
+}
+
+func (c *CodeTable) add(words []string, phrase codePhrase) {
+	if len(words) == 0 {
+		return
+	}
+	c.phrases[strings.Join(words, " ")] = phrase
+	c.maxWords = max(c.maxWords, len(words))
+}
+
+// codeWords splits a code as transcribed into lowercase words, joining radio codes such as
+// "10-9-7" or the spoken "ten-97" into "1097"
+func codeWords(text string) []string {
+	words := wordsRegex.FindAllString(strings.ToLower(text), -1)
+	for i, word := range words {
+		word = spokenCodeReplacer.Replace(word)
+		if compact := strings.ReplaceAll(word, "-", ""); isNumber(compact) {
+			words[i] = compact
+		}
+	}
+	return words
+}
+
+// spokenCodeReplacer spells out the spoken prefix of radio codes, e.g. "ten-71"
+var spokenCodeReplacer = strings.NewReplacer("ten-", "10-", "eleven-", "11-")
+
+// Extract finds the incident details in the lowercase transcript text. The number of the call's
+// address is not taken for a code.
+func (c *CodeTable) Extract(text string, addr Address) (details IncidentDetails) {
+	if c == nil {
+		return details
+	}
+	if m := collisionRegex.FindStringSubmatch(text); m != nil {
+		collision := collisionModes[m[1]] + " vs " + collisionModes[m[2]]
+		details.Type = strings.ToUpper(collision[:1]) + collision[1:]
+	}
+	addrNumber, _, _ := strings.Cut(addr.PrimaryAddress, " ")
+
+	raw := wordsRegex.FindAllString(text, -1)
+	words := codeWords(text)
+	for i := 0; i < len(words); i++ {
+		if unit, ok := c.units[words[i]]; ok && i+1 < len(words) && isNumber(words[i+1]) {
+			if unit := unit + " " + words[i+1]; !slices.Contains(details.Units, unit) {
+				details.Units = append(details.Units, unit)
+			}
+			i++
+			continue
+		}
+		if words[i] == addrNumber {
+			continue
+		}
+
+		for n := min(c.maxWords, len(words)-i); n > 0; n-- {
+			phrase, ok := c.phrases[strings.Join(words[i:i+n], " ")]
+			if !ok || (phrase.hyphenated && !strings.Contains(raw[i], "-")) {
+				continue
+			}
+			if phrase.status != "" {
+				details.Status = phrase.status
+			}
+			if code := phrase.code; code != nil {
+				if details.Type == "" && code.Kind == codeKindPenal {
+					details.Type = code.Meaning
+				}
+				meaning := CodeMeaning{Code: code.Code, Meaning: code.Meaning}
+				if !slices.Contains(details.Codes, meaning) {
+					details.Codes = append(details.Codes, meaning)
+				}
+			}
+			i += n - 1
+			break
+		}
+	}
+	return details
+}
+
+// IsZero reports whether nothing was extracted
+func (details IncidentDetails) IsZero() bool {
+	return details.Type == "" && len(details.Codes) == 0 && len(details.Units) == 0 && details.Status == ""
+}
+
+// String summarizes the details on one line, e.g. "Battery | code 3 Lights and siren | Engine 2 | on scene"
+func (details IncidentDetails) String() string {
+	var parts, codes []string
+	if details.Type != "" {
+		parts = append(parts, details.Type)
+	}
+	for _, code := range details.Codes {
+		if code.Meaning != details.Type {
+			codes = append(codes, code.Code+" "+code.Meaning)
+		}
+	}
+	if len(codes) > 0 {
+		parts = append(parts, strings.Join(codes, ", "))
+	}
+	if len(details.Units) > 0 {
+		parts = append(parts, strings.Join(details.Units, ", "))
+	}
+	if details.Status != "" {
+		parts = append(parts, details.Status)
+	}
+	return strings.Join(parts, " | ")
+}
+
+// callDetails extracts the incident details from the call's transcript
+func callDetails(meta Metadata) IncidentDetails {
+	text := callText(meta)
+	return codeTable.Extract(text, gazetteer.Extract(meta, text))
+}
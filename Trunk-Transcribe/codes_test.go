@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallDetails(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		expect IncidentDetails
+	}{
+		{
+			name: "nothing",
+			text: "114 Control, do you have traffic? Affirm, we have a car on our way",
+		},
+		{
+			name: "status and radio code",
+			text: "Can you tell me one more time? you got me en route to 1071 in the SRT van? In the SRT van",
+			expect: IncidentDetails{
+				Codes:  []CodeMeaning{{Code: "10-71", Meaning: "Shooting"}},
+				Status: "en route",
+			},
+		},
+		{
+			name: "hyphenated radio codes",
+			text: "112, Tom, attach me to the 1033, Frank, and I'm 10-9-7.",
+			expect: IncidentDetails{
+				Codes:  []CodeMeaning{{Code: "10-33", Meaning: "Emergency traffic"}, {Code: "10-97", Meaning: "Arrived on scene"}},
+				Status: "on scene",
+			},
+		},
+		{
+			name:   "radio code as whisper writes it",
+			text:   "We have an 1199 at Ashby",
+			expect: IncidentDetails{Codes: []CodeMeaning{{Code: "11-99", Meaning: "Officer needs help"}}},
+		},
+		{
+			name:   "radio code leading the call",
+			text:   "1199 officer needs help",
+			expect: IncidentDetails{Codes: []CodeMeaning{{Code: "11-99", Meaning: "Officer needs help"}}},
+		},
+		{
+			name: "spoken radio codes",
+			text: "Ten-33 on the channel, we have an eleven-80 at Ashby and Shattuck, ten four",
+			expect: IncidentDetails{
+				Codes: []CodeMeaning{
+					{Code: "10-33", Meaning: "Emergency traffic"},
+					{Code: "11-80", Meaning: "Major injury collision"},
+					{Code: "10-4", Meaning: "Acknowledged"},
+				},
+			},
+		},
+		{
+			name:   "collision",
+			text:   "Fancroft and Piedmont,we've got a vehicle versus bike, and we've got an involved party on the phone,we've got BFD and RUN as well",
+			expect: IncidentDetails{Type: "Vehicle vs bicycle"},
+		},
+		{
+			name:   "abbreviated collision",
+			text:   "Fancroft and Piedmont,we've got a auto vs. ped, and we've got an involved party on the phone,we've got BFD and RUN as well",
+			expect: IncidentDetails{Type: "Vehicle vs pedestrian"},
+		},
+		{
+			name: "penal code",
+			text: " It's going to be a good 242 with the prosecution requested, BFD declined, and clear for a suspect description.",
+			expect: IncidentDetails{
+				Type:  "Battery",
+				Codes: []CodeMeaning{{Code: "242", Meaning: "Battery"}},
+			},
+		},
+		{
+			name:   "radio code that is not a number",
+			text:   "Can you mark a 10-15 time? Copy, 16-05",
+			expect: IncidentDetails{Codes: []CodeMeaning{{Code: "10-15", Meaning: "Prisoner in custody"}}},
+		},
+		{
+			name: "units and the address number",
+			text: "Engine 2 and Medic 5 respond code 3 to 459 Bancroft, Engine 2",
+			expect: IncidentDetails{
+				Codes: []CodeMeaning{{Code: "code 3", Meaning: "Lights and siren"}},
+				Units: []string{"Engine 2", "Medic 5"},
+			},
+		},
+		{
+			name: "short radio code needs its hyphen",
+			text: "Copy 104, 10-4, we're code four",
+			expect: IncidentDetails{
+				Codes:  []CodeMeaning{{Code: "10-4", Meaning: "Acknowledged"}, {Code: "code 4", Meaning: "No further assistance needed"}},
+				Status: "code 4",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, callDetails(Metadata{AudioText: test.text}))
+		})
+	}
+}
+
+func TestIncidentDetailsString(t *testing.T) {
+	details := IncidentDetails{
+		Type:   "Battery",
+		Codes:  []CodeMeaning{{Code: "242", Meaning: "Battery"}, {Code: "code 3", Meaning: "Lights and siren"}},
+		Units:  []string{"Engine 2", "Medic 5"},
+		Status: "on scene",
+	}
+	assert.Equal(t, "Battery | code 3 Lights and siren | Engine 2, Medic 5 | on scene", details.String())
+
+	_, err := ParseCodeTable([]byte(`{"codes": [{"code": "211"}]}`))
+	require.EqualError(t, err, "code 0: missing code or meaning")
+}
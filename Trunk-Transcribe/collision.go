@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// COLLISION_SUMMARY_CHANNEL is the channel, a routing file name or a slack channel id, that the
+// weekly summary of bike and pedestrian collisions is posted to. No summary is posted if it is
+// empty.
+var collisionSummaryChannel string = os.Getenv("COLLISION_SUMMARY_CHANNEL")
+
+const (
+	// the weekly summary covers the week up to this time on Monday in Berkeley
+	collisionSummaryWeekday = time.Monday
+	collisionSummaryHour    = 8
+	// how far back /api/collisions looks by default
+	collisionExportWindow = 30 * 24 * time.Hour
+)
+
+// the collision modes of the bike lobby's tracker, one of which must be involved
+var collisionTrackedModes = []string{"bicycle", "pedestrian"}
+
+var (
+	collisionModeRegex = regexp.MustCompile(`\b` + modeString + `\b`)
+	struckRegex        = regexp.MustCompile(`\b(hit|struck|run over) by (a |an )?` + modeString + `\b`)
+)
+
+// severityPattern matches the phrases that indicate an injury severity
+type severityPattern struct {
+	severity string
+	regex    *regexp.Regexp
+}
+
+// collision injury severities, most severe first
+var collisionSeverities = []severityPattern{
+	{"fatal", regexp.MustCompile(`\b(fatal|fatality|deceased|doa|not breathing|cpr|code blue)\b`)},
+	{"major", regexp.MustCompile(`\b(major injur(y|ies)|11-80|unconscious|unresponsive|not conscious|head injury|bleeding|critical)\b`)},
+	{"minor", regexp.MustCompile(`\b(minor injur(y|ies)|11-81|complaint of pain|abrasions?|conscious and breathing)\b`)},
+	{"none", regexp.MustCompile(`\b(no injur(y|ies)|non-injury|not injured|uninjured|11-82)\b`)},
+}
+
+// Collision is a bike or pedestrian collision, merged from the calls about it
+type Collision struct {
+	ID         int64     `json:"id"`
+	FirstCall  time.Time `json:"first_call"`
+	LastCall   time.Time `json:"last_call"`
+	System     string    `json:"system"`
+	Talkgroup  int64     `json:"talkgroup"`
+	Modes      []string  `json:"modes"`              // e.g. ["bicycle", "vehicle"]
+	Severity   string    `json:"severity,omitempty"` // fatal, major, minor, none or unknown if empty
+	Address    string    `json:"address,omitempty"`  // as transcribed
+	Normalized string    `json:"normalized,omitempty"`
+	Location   *LatLon   `json:"location,omitempty"`
+	Calls      []string  `json:"calls"` // keys of the calls
+}
+
+// CollisionLog records the bike and pedestrian collisions heard on calls
+type CollisionLog struct {
+	db     *sql.DB
+	window time.Duration // calls this close together about the same place are merged
+}
+
+func NewCollisionLog(ctx context.Context, db *sql.DB, window time.Duration) (*CollisionLog, error) {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS collisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			first_call INTEGER NOT NULL,
+			last_call INTEGER NOT NULL,
+			system TEXT NOT NULL,
+			talkgroup INTEGER NOT NULL,
+			modes TEXT NOT NULL,
+			severity TEXT NOT NULL,
+			address TEXT NOT NULL,
+			normalized TEXT NOT NULL,
+			lat REAL,
+			lon REAL
+		);
+		CREATE INDEX IF NOT EXISTS collisions_first_call ON collisions (first_call);
+		CREATE INDEX IF NOT EXISTS collisions_last_call ON collisions (last_call);
+
+		CREATE TABLE IF NOT EXISTS collision_calls (
+			key TEXT PRIMARY KEY,
+			collision_id INTEGER NOT NULL REFERENCES collisions (id),
+			start_time INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS collision_calls_collision ON collision_calls (collision_id, start_time);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return &CollisionLog{db: db, window: window}, nil
+}
+
+// collisionModesIn returns the modes involved if the transcript describes a collision with a bike
+// or pedestrian, e.g. "auto versus ped" or "cyclist struck by a car"
+func collisionModesIn(text string) (modes []string) {
+	if !versusRegex.MatchString(text) && !struckRegex.MatchString(text) {
+		return nil
+	}
+	for _, m := range collisionModeRegex.FindAllStringSubmatch(text, -1) {
+		if mode := collisionModes[m[1]]; !slices.Contains(modes, mode) {
+			modes = append(modes, mode)
+		}
+	}
+	if !slices.ContainsFunc(modes, func(mode string) bool { return slices.Contains(collisionTrackedModes, mode) }) {
+		return nil
+	}
+	slices.Sort(modes)
+	return modes
+}
+
+// collisionSeverity returns the most severe injury described in the transcript
+func collisionSeverity(text string) string {
+	for _, s := range collisionSeverities {
+		if s.regex.MatchString(text) {
+			return s.severity
+		}
+	}
+	return ""
+}
+
+// severityRank orders severities from unknown (0) to fatal
+func severityRank(severity string) int {
+	i := slices.IndexFunc(collisionSeverities, func(s severityPattern) bool { return s.severity == severity })
+	if i < 0 {
+		return 0
+	}
+	return len(collisionSeverities) - i
+}
+
+// Record logs the call if it describes a bike or pedestrian collision. A call about the same
+// place, or on the same talkgroup when either has no address, within the window of the
+// collision's last call is merged into it. Recording a call again has no effect.
+func (c *CollisionLog) Record(ctx context.Context, key string, meta Metadata) error {
+	if c == nil {
+		return nil
+	}
+	text := callText(meta)
+	modes := collisionModesIn(text)
+	if len(modes) == 0 {
+		return nil
+	}
+	addr := geocoder.Geocode(meta, gazetteer.Extract(meta, text))
+	start := time.Now()
+	if meta.StartTime > 0 {
+		start = time.Unix(meta.StartTime, 0)
+	}
+	call := Collision{
+		FirstCall:  start,
+		LastCall:   start,
+		System:     meta.ShortName,
+		Talkgroup:  meta.Talkgroup,
+		Modes:      modes,
+		Severity:   collisionSeverity(text),
+		Address:    addr.String(),
+		Normalized: addr.Normalized,
+		Location:   addr.Location,
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var recorded bool
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM collision_calls WHERE key = ?`, key).Scan(&recorded); err != nil || recorded {
+		return err
+	}
+
+	candidates, err := queryCollisions(ctx, tx, `
+		WHERE system = ? COLLATE NOCASE AND last_call >= ? AND first_call <= ?
+		ORDER BY last_call DESC`,
+		call.System, start.Add(-c.window).Unix(), start.Add(c.window).Unix())
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(candidates, call.sameAs)
+	if i < 0 {
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO collisions (first_call, last_call, system, talkgroup, modes, severity, address, normalized, lat, lon)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			start.Unix(), start.Unix(), call.System, call.Talkgroup, strings.Join(call.Modes, ","), call.Severity,
+			call.Address, call.Normalized, locationLat(call.Location), locationLon(call.Location)).Scan(&call.ID)
+	} else {
+		merged := candidates[i].merge(call)
+		call.ID = merged.ID
+		_, err = tx.ExecContext(ctx, `
+			UPDATE collisions SET first_call = ?, last_call = ?, modes = ?, severity = ?, address = ?, normalized = ?, lat = ?, lon = ?
+			WHERE id = ?`,
+			merged.FirstCall.Unix(), merged.LastCall.Unix(), strings.Join(merged.Modes, ","), merged.Severity,
+			merged.Address, merged.Normalized, locationLat(merged.Location), locationLon(merged.Location), merged.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO collision_calls (key, collision_id, start_time) VALUES (?, ?, ?)`, key, call.ID, start.Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sameAs reports whether the call is about the collision: the same address, or the same
+// talkgroup if either has no address
+func (call Collision) sameAs(collision Collision) bool {
+	if call.Address != "" && collision.Address != "" {
+		return strings.EqualFold(call.Address, collision.Address) ||
+			(call.Normalized != "" && call.Normalized == collision.Normalized)
+	}
+	return call.Talkgroup == collision.Talkgroup
+}
+
+// merge adds the call to the collision, keeping the most severe injury and the first address
+func (collision Collision) merge(call Collision) Collision {
+	if call.FirstCall.Before(collision.FirstCall) {
+		collision.FirstCall = call.FirstCall
+	}
+	if call.LastCall.After(collision.LastCall) {
+		collision.LastCall = call.LastCall
+	}
+	for _, mode := range call.Modes {
+		if !slices.Contains(collision.Modes, mode) {
+			collision.Modes = append(collision.Modes, mode)
+		}
+	}
+	slices.Sort(collision.Modes)
+	if severityRank(call.Severity) > severityRank(collision.Severity) {
+		collision.Severity = call.Severity
+	}
+	if collision.Address == "" || (collision.Location == nil && call.Location != nil) {
+		collision.Address, collision.Normalized, collision.Location = call.Address, call.Normalized, call.Location
+	}
+	return collision
+}
+
+// locationLat and locationLon are the coordinates of the location as nullable sql values
+func locationLat(location *LatLon) any {
+	if location == nil {
+		return nil
+	}
+	return location.Lat
+}
+
+func locationLon(location *LatLon) any {
+	if location == nil {
+		return nil
+	}
+	return location.Lon
+}
+
+// queryCollisions selects the collisions matching the sql filter, with their calls in order
+func queryCollisions(ctx context.Context, tx *sql.Tx, filter string, args ...any) ([]Collision, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, first_call, last_call, system, talkgroup, modes, severity, address, normalized, lat, lon,
+			(SELECT group_concat(key, ' ') FROM (SELECT key FROM collision_calls WHERE collision_id = collisions.id ORDER BY start_time, key))
+		FROM collisions `+filter, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collisions []Collision
+	for rows.Next() {
+		var collision Collision
+		var first, last int64
+		var modes string
+		var lat, lon sql.NullFloat64
+		var calls sql.NullString
+		err := rows.Scan(&collision.ID, &first, &last, &collision.System, &collision.Talkgroup, &modes,
+			&collision.Severity, &collision.Address, &collision.Normalized, &lat, &lon, &calls)
+		if err != nil {
+			return nil, err
+		}
+		collision.FirstCall = time.Unix(first, 0).In(location)
+		collision.LastCall = time.Unix(last, 0).In(location)
+		collision.Modes = strings.Split(modes, ",")
+		if lat.Valid && lon.Valid {
+			collision.Location = &LatLon{Lat: lat.Float64, Lon: lon.Float64}
+		}
+		collision.Calls = strings.Fields(calls.String)
+		collisions = append(collisions, collision)
+	}
+	return collisions, rows.Err()
+}
+
+// List returns the collisions first heard in [from, to), oldest first
+func (c *CollisionLog) List(ctx context.Context, from, to time.Time) ([]Collision, error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return queryCollisions(ctx, tx, `WHERE first_call >= ? AND first_call < ? ORDER BY first_call, id`, from.Unix(), to.Unix())
+}
+
+// recordCollision logs the call if it is a collision, logging rather than failing the request if
+// it can't
+func recordCollision(ctx context.Context, config *Config, key string, meta Metadata) {
+	if config == nil {
+		return
+	}
+	if err := config.collisions.Record(context.WithoutCancel(ctx), key, meta); err != nil {
+		log.Printf("[collisions] Error recording %s: %v", key, err)
+	}
+}
+
+// handleCollisions serves GET /api/collisions, the collisions first heard between from and to
+// (the last 30 days by default) as GeoJSON, or as CSV with format=csv
+func handleCollisions(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		to := time.Now()
+		from := to.Add(-collisionExportWindow)
+		for name, dest := range map[string]*time.Time{"from": &from, "to": &to} {
+			if v := values.Get(name); v != "" {
+				t, err := parseTime(v)
+				if err != nil {
+					http.Error(w, "invalid "+name+": "+v, http.StatusBadRequest)
+					return
+				}
+				*dest = t
+			}
+		}
+
+		collisions, err := config.collisions.List(r.Context(), from, to)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		switch format := values.Get("format"); format {
+		case "", "geojson":
+			w.Header().Set("Content-Type", "application/geo+json")
+			json.NewEncoder(w).Encode(collisionsGeoJSON(collisions))
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="collisions.csv"`)
+			writeCollisionsCSV(w, collisions)
+		default:
+			http.Error(w, "invalid format: "+format, http.StatusBadRequest)
+		}
+	}
+}
+
+// audioLinks returns the playback urls of the collision's calls
+func (collision Collision) audioLinks() []string {
+	links := make([]string, len(collision.Calls))
+	for i, key := range collision.Calls {
+		links[i] = publicURL + "/audio?link=" + url.QueryEscape(key)
+	}
+	return links
+}
+
+// collisionsGeoJSON returns the collisions as a GeoJSON FeatureCollection. Collisions that could
+// not be located have no geometry.
+func collisionsGeoJSON(collisions []Collision) map[string]any {
+	features := []any{}
+	for _, collision := range collisions {
+		var geometry any
+		if collision.Location != nil {
+			geometry = map[string]any{"type": "Point", "coordinates": []float64{collision.Location.Lon, collision.Location.Lat}}
+		}
+		features = append(features, map[string]any{
+			"type":     "Feature",
+			"id":       collision.ID,
+			"geometry": geometry,
+			"properties": map[string]any{
+				"first_call": collision.FirstCall.Format(time.RFC3339),
+				"last_call":  collision.LastCall.Format(time.RFC3339),
+				"system":     collision.System,
+				"talkgroup":  collision.Talkgroup,
+				"modes":      collision.Modes,
+				"severity":   collision.Severity,
+				"address":    collision.Address,
+				"normalized": collision.Normalized,
+				"calls":      collision.audioLinks(),
+			},
+		})
+	}
+	return map[string]any{"type": "FeatureCollection", "features": features}
+}
+
+func writeCollisionsCSV(w http.ResponseWriter, collisions []Collision) {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "first_call", "last_call", "system", "talkgroup", "modes", "severity", "address", "normalized", "lat", "lon", "calls"})
+	for _, collision := range collisions {
+		var lat, lon string
+		if collision.Location != nil {
+			lat = strconv.FormatFloat(collision.Location.Lat, 'f', 5, 64)
+			lon = strconv.FormatFloat(collision.Location.Lon, 'f', 5, 64)
+		}
+		writer.Write([]string{
+			strconv.FormatInt(collision.ID, 10),
+			collision.FirstCall.Format(time.RFC3339),
+			collision.LastCall.Format(time.RFC3339),
+			collision.System,
+			strconv.FormatInt(collision.Talkgroup, 10),
+			strings.Join(collision.Modes, ";"),
+			collision.Severity,
+			collision.Address,
+			collision.Normalized,
+			lat,
+			lon,
+			strings.Join(collision.audioLinks(), " "),
+		})
+	}
+	writer.Flush()
+}
+
+// collisionSummary is the slack message summarizing the week's collisions
+func collisionSummary(collisions []Collision, from, to time.Time) string {
+	lines := []string{fmt.Sprintf("*Bike and pedestrian collisions* | %s to %s | %d collisions",
+		from.In(location).Format("Mon Jan 2"), to.Add(-time.Second).In(location).Format("Mon Jan 2"), len(collisions))}
+
+	var counts []string
+	for _, mode := range collisionTrackedModes {
+		n := 0
+		for _, collision := range collisions {
+			if slices.Contains(collision.Modes, mode) {
+				n++
+			}
+		}
+		counts = append(counts, fmt.Sprintf("%s %d", mode, n))
+	}
+	for _, s := range collisionSeverities {
+		n := 0
+		for _, collision := range collisions {
+			if collision.Severity == s.severity {
+				n++
+			}
+		}
+		if n > 0 {
+			counts = append(counts, fmt.Sprintf("%s injury %d", s.severity, n))
+		}
+	}
+	lines = append(lines, strings.Join(counts, " | "))
+
+	for _, collision := range collisions {
+		line := "• " + collision.FirstCall.In(location).Format("Mon 3:04PM") + " " + strings.Join(collision.Modes, " / ")
+		switch {
+		case collision.Location != nil:
+			line += fmt.Sprintf(" at <%s|%s>", collision.Location.MapURL(), collision.Normalized)
+		case collision.Address != "":
+			line += " at " + collision.Address
+		}
+		if collision.Severity != "" {
+			line += " (" + collision.Severity + " injury)"
+		}
+		if links := collision.audioLinks(); len(links) > 0 {
+			line += fmt.Sprintf(" <%s|Audio>", links[0])
+		}
+		lines = append(lines, line)
+	}
+
+	export := url.Values{"format": {"csv"}, "from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}}
+	lines = append(lines, fmt.Sprintf("<%s/api/collisions?%s|Download CSV>", publicURL, export.Encode()))
+	return strings.Join(lines, "\n")
+}
+
+// nextCollisionSummary returns when the summary after now is due
+func nextCollisionSummary(now time.Time) time.Time {
+	now = now.In(location)
+	days := (int(collisionSummaryWeekday) - int(now.Weekday()) + 7) % 7
+	next := time.Date(now.Year(), now.Month(), now.Day()+days, collisionSummaryHour, 0, 0, 0, location)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+// postCollisionSummaries posts the summary of the past week's collisions to the channel every
+// week until ctx is done
+func postCollisionSummaries(ctx context.Context, config *Config, channel SlackChannelID) {
+	for {
+		next := nextCollisionSummary(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		from := next.AddDate(0, 0, -7)
+		collisions, err := config.collisions.List(ctx, from, next)
+		if err != nil {
+			log.Println("[collisions] Error listing collisions for summary: ", err)
+			continue
+		}
+		client := config.slackClientSecondary
+		if slices.Contains(PRIMARY_CHANNELS, channel) {
+			client = config.slackClient
+		}
+		err = retry(ctx, stepSlack, func(ctx context.Context) error {
+			_, _, err := client.PostMessageContext(ctx, string(channel), slack.MsgOptionText(collisionSummary(collisions, from, next), false))
+			return err
+		})
+		if err != nil {
+			log.Println("[collisions] Error posting summary: ", err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollisionModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		modes    []string
+		severity string
+	}{
+		{
+			name:  "vehicle versus bike",
+			text:  "Fancroft and Piedmont,we've got a vehicle versus bike, and we've got an involved party on the phone,we've got BFD and RUN as well",
+			modes: []string{"bicycle", "vehicle"},
+		},
+		{
+			name:  "abbreviated",
+			text:  "Fancroft and Piedmont,we've got a auto vs. ped, and we've got an involved party on the phone,we've got BFD and RUN as well",
+			modes: []string{"pedestrian", "vehicle"},
+		},
+		{
+			name:  "bicycle versus ped",
+			text:  "Fancroft and Piedmont,we've got a bicycle versus ped, and we've got an involved party on the phone,we've got BFD and RUN as well",
+			modes: []string{"bicycle", "pedestrian"},
+		},
+		{
+			name:     "struck by",
+			text:     "Cyclist struck by a car at Shattuck and Ward, conscious and breathing",
+			modes:    []string{"bicycle", "vehicle"},
+			severity: "minor",
+		},
+		{
+			name:     "most severe injury",
+			text:     "Auto versus pedestrian, the pedestrian is unconscious, complaint of pain from the driver",
+			modes:    []string{"pedestrian", "vehicle"},
+			severity: "major",
+		},
+		{
+			name: "no bike or pedestrian",
+			text: "Car versus car at Ashby and Telegraph, no injuries",
+		},
+		{
+			name: "not a collision",
+			text: "114 Control, do you have traffic? Affirm, we have a car on our way",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text := callText(Metadata{AudioText: test.text})
+			assert.Equal(t, test.modes, collisionModesIn(text))
+			if test.modes != nil {
+				assert.Equal(t, test.severity, collisionSeverity(text))
+			}
+		})
+	}
+}
+
+func TestCollisionLog(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	collisions, err := NewCollisionLog(ctx, db, 15*time.Minute)
+	require.NoError(t, err)
+
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+	start := time.Unix(meta.StartTime, 0)
+	record := func(key string, after time.Duration, text string) {
+		call := meta
+		call.StartTime = start.Add(after).Unix()
+		call.AudioText = text
+		call.Segments = nil
+		require.NoError(t, collisions.Record(ctx, key, call))
+	}
+	record("a.wav", 0, "Russell and California, we've got a vehicle versus bike, complaint of pain")
+	record("b.wav", 5*time.Minute, "Update on the bike versus auto, the rider is unconscious")
+	record("c.wav", 6*time.Minute, "Car versus ped at 2605 Durant, no injuries")
+	record("d.wav", time.Hour, "Second auto vs. ped")
+	record("e.wav", time.Hour, "Car versus car at Ashby and Telegraph")
+	record("a.wav", 0, "Russell and California, we've got a vehicle versus bike, complaint of pain")
+
+	list, err := collisions.List(ctx, start, start.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+
+	assert.Equal(t, []string{"a.wav", "b.wav"}, list[0].Calls)
+	assert.Equal(t, []string{"bicycle", "vehicle"}, list[0].Modes)
+	assert.Equal(t, "major", list[0].Severity)
+	assert.Equal(t, "Russell St & California St, Berkeley", list[0].Normalized)
+	assert.Equal(t, start.Add(5*time.Minute).Unix(), list[0].LastCall.Unix())
+
+	assert.Equal(t, []string{"c.wav"}, list[1].Calls)
+	assert.Equal(t, "2605 Durant", list[1].Address)
+	assert.Equal(t, "none", list[1].Severity)
+	require.NotNil(t, list[1].Location)
+
+	assert.Equal(t, []string{"d.wav"}, list[2].Calls)
+	assert.Nil(t, list[2].Location)
+
+	summary := collisionSummary(list, start, start.Add(7*24*time.Hour))
+	assert.Contains(t, summary, "| 3 collisions\nbicycle 1 | pedestrian 2 | major injury 1 | none injury 1\n")
+	assert.Contains(t, summary, "pedestrian / vehicle at <https://www.google.com/maps/search/?api=1&query=37.86760,-122.25635|2605 Durant Ave, Berkeley> (none injury)")
+
+	mux := mux(&Config{collisions: collisions}, nil)
+	get := func(query string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/api/collisions?from=1702617000&to=1702630000"+query, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := get("&format=csv")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, []string{"1", "bicycle;vehicle", "major", "Russell and California"}, []string{rows[1][0], rows[1][5], rows[1][6], rows[1][7]})
+
+	rr = get("")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var geojson struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry *struct {
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &geojson))
+	assert.Equal(t, "FeatureCollection", geojson.Type)
+	require.Len(t, geojson.Features, 3)
+	assert.Equal(t, []float64{-122.25635, 37.8676}, geojson.Features[1].Geometry.Coordinates)
+	assert.Nil(t, geojson.Features[2].Geometry)
+
+	assert.Equal(t, http.StatusBadRequest, get("&format=kml").Code)
+}
+
+func TestNextCollisionSummary(t *testing.T) {
+	wednesday := time.Date(2023, 12, 13, 10, 0, 0, 0, location)
+	monday := time.Date(2023, 12, 18, 8, 0, 0, 0, location)
+	assert.Equal(t, monday, nextCollisionSummary(wednesday))
+	assert.Equal(t, monday.AddDate(0, 0, 7), nextCollisionSummary(monday))
+	assert.Equal(t, monday, nextCollisionSummary(monday.Add(-time.Minute)))
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"log"
 	"regexp"
-	"strings"
+	"slices"
 )
 
 var (
@@ -12,213 +11,20 @@ var (
 	numericRegex = regexp.MustCompile("[0-9]+")
 	modeString   = `(auto|car|driver|vehicle|bike|pedestrian|ped|bicycle|cyclist|bicyclist|pavement)s?`
 	versusRegex  = regexp.MustCompile(modeString + `.+(vs|versus|verses)(\.)?.+` + modeString)
-	streets      = []string{"Acton", "Ada", "Addison", "Adeline", "Alcatraz", "Allston", "Ashby", "Bancroft", "Benvenue", "Berryman", "Blake", "Bonar", "Bonita", "Bowditch", "Buena", "California", "Camelia", "Carleton", "Carlotta", "Cedar", "Center", "Channing", "Chestnut", "Claremont", "Codornices", "College", "Cragmont", "Delaware", "Derby", "Dwight", "Eastshore", "Edith", "Elmwood", "Euclid", "Francisco", "Fresno", "Gilman", "Grizzly", "Harrison", "Hearst", "Heinz", "Henry", "Hillegass", "Holly", "Hopkins", "Josephine", "Kains", "Keoncrest", "King", "LeConte", "LeRoy", "Hilgard", "Mabel", "Marin", "Martin", "MLK", "Milvia", "Monterey", "Napa", "Neilson", "Oregon", "Parker", "Piedmont", "Posen", "Rose", "Russell", "Sacramento", "San Pablo", "Santa", "Fe", "Shattuck", "Solano", "Sonoma", "Spruce", "Telegraph", "Alameda", "Thousand", "Oaks", "University", "Vine", "Virginia", "Ward", "Woolsey"}
 	modifiers    = []string{"street", "boulevard", "road", "path", "way", "avenue", "highway"}
 	terms        = []string{"bike", "bicycle", "pedestrian", "vehicle", "injury", "victim", "versus", "transport", "concious", "breathing", "alta bates", "highland", "BFD", "Adam", "ID tech", "ring on three", "code 2", "code 3", "code 4", "code 34", "en route", "case number", "berry brothers", "rita run", "DBF", "Falck", "Falck on order", "this is Falck", "Flock camera", "10-four", "10-4", "10 four", "His Lordships", "Cesar Chavez Park", "10-9 your traffic", "copy", "tow", "the beat"}
 
 	defaultChannelID = BERKELEY // #scanner-dispatches
 
-	talkgroupToChannel = map[int64][]SlackChannelID{
-		2100:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2105:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2106:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2107:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2108:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2109:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2110:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2111:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2112:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2671:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2672:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2691:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2692:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2711:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		2712:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		3100:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		3105:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		3106:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		3108:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		3110:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		3112:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4100:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4105:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4106:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4107:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4108:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4109:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4110:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4111:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		4112:                          []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
-		ALTA_BATES_HOSPITAL_TALKGROUP: []SlackChannelID{HOSPITALS},
-		3605:                          []SlackChannelID{UCPD},
-		3606:                          []SlackChannelID{UCPD},
-		3608:                          []SlackChannelID{UCPD},
-		3609:                          []SlackChannelID{UCPD},
-		3055:                          []SlackChannelID{ALBANY},
-		3056:                          []SlackChannelID{ALBANY},
-		3057:                          []SlackChannelID{ALBANY},
-		3058:                          []SlackChannelID{ALBANY},
-		3059:                          []SlackChannelID{ALBANY},
-		2050:                          []SlackChannelID{ALBANY},
-		2055:                          []SlackChannelID{ALBANY},
-		2056:                          []SlackChannelID{ALBANY},
-		2057:                          []SlackChannelID{ALBANY},
-		2058:                          []SlackChannelID{ALBANY},
-		2059:                          []SlackChannelID{ALBANY},
-		4055:                          []SlackChannelID{ALBANY},
-		3155:                          []SlackChannelID{EMERYVILLE},
-		3156:                          []SlackChannelID{EMERYVILLE},
-		3157:                          []SlackChannelID{EMERYVILLE},
-		4155:                          []SlackChannelID{EMERYVILLE},
-		3405:                          []SlackChannelID{OAKLAND},
-		3406:                          []SlackChannelID{OAKLAND},
-		3407:                          []SlackChannelID{OAKLAND},
-		3408:                          []SlackChannelID{OAKLAND},
-		3409:                          []SlackChannelID{OAKLAND},
-		3410:                          []SlackChannelID{OAKLAND},
-		3411:                          []SlackChannelID{OAKLAND},
-		3418:                          []SlackChannelID{OAKLAND},
-		3419:                          []SlackChannelID{OAKLAND},
-		3420:                          []SlackChannelID{OAKLAND},
-		3421:                          []SlackChannelID{OAKLAND},
-		3422:                          []SlackChannelID{OAKLAND},
-		3423:                          []SlackChannelID{OAKLAND},
-		3424:                          []SlackChannelID{OAKLAND},
-		3425:                          []SlackChannelID{OAKLAND},
-		3426:                          []SlackChannelID{OAKLAND},
-		3428:                          []SlackChannelID{OAKLAND},
-		3429:                          []SlackChannelID{OAKLAND},
-		3447:                          []SlackChannelID{OAKLAND},
-		3448:                          []SlackChannelID{OAKLAND},
-		2400:                          []SlackChannelID{OAKLAND_FIRE},
-		2405:                          []SlackChannelID{OAKLAND_FIRE},
-		2406:                          []SlackChannelID{OAKLAND_FIRE},
-		2407:                          []SlackChannelID{OAKLAND_FIRE},
-		2408:                          []SlackChannelID{OAKLAND_FIRE},
-		2409:                          []SlackChannelID{OAKLAND_FIRE},
-		2410:                          []SlackChannelID{OAKLAND_FIRE},
-		2411:                          []SlackChannelID{OAKLAND_FIRE},
-		2412:                          []SlackChannelID{OAKLAND_FIRE},
-		2413:                          []SlackChannelID{OAKLAND_FIRE},
-		2414:                          []SlackChannelID{OAKLAND_FIRE},
-		2416:                          []SlackChannelID{OAKLAND_FIRE},
-		2417:                          []SlackChannelID{OAKLAND_FIRE},
-		2434:                          []SlackChannelID{OAKLAND_FIRE},
-		2436:                          []SlackChannelID{OAKLAND_FIRE},
-		4405:                          []SlackChannelID{OAKLAND},
-		4407:                          []SlackChannelID{OAKLAND},
-		4415:                          []SlackChannelID{OAKLAND},
-		4421:                          []SlackChannelID{OAKLAND},
-		4422:                          []SlackChannelID{OAKLAND},
-		4423:                          []SlackChannelID{OAKLAND},
-		CHILDRENS_HOSPITAL_TALKGROUP:  []SlackChannelID{HOSPITALS_TRAUMA},        // Childrens Hospital
-		HIGHLAND_HOSPITAL_TALKGROUP:   []SlackChannelID{HOSPITALS_TRAUMA}, // Highland Hospital
-		5516:                          []SlackChannelID{HOSPITALS},               // Summit Hospital
-		5512:                          []SlackChannelID{HOSPITALS},
-	}
-
-	// Determines slack channel to send to from the passed metadata
+	// Determines slack channel to send to from the passed metadata. Driven by the routing file,
+	// see routing.go, and the notifications file if one is loaded, see notifications.go
 	channelResolver = func(meta Metadata) []SlackChannelID {
-		channels, ok := talkgroupToChannel[meta.Talkgroup]
-		if ok {
-			return channels
-		}
-
-		talkgroupGroup := strings.ToLower(meta.TalkGroupGroup)
-		talkgroupTag := strings.ToLower(meta.TalkgroupTag)
-		switch talkgroupGroup {		
-		case "al co sheriff":
-			channels = append(channels, ALAMEDA_COUNTY)
-		case "al co ems":
-			channels = append(channels, ALAMEDA_COUNTY_EMS)
-		case "al co fire":
-			channels = append(channels, ALAMEDA_COUNTY_FIRE)
-		case "al co services":
-			channels = append(channels, ALAMEDA_COUNTY_SERVICES)
-		case "alameda":
-			channels = append(channels, ALAMEDA)
-		case "amr (ccc)":
-			channels = append(channels, AMR_CCC)
-		case "berkeley":
-			channels = append(channels, BERKELEY, BERKELEY_SECONDARY)
-		case "oakland":
-			switch talkgroupTag {
-			case "fire dispatch":
-				channels = append(channels, OAKLAND, OAKLAND_FIRE_SECONDARY)
-			default:
-				channels = append(channels, OAKLAND, OAKLAND_SECONDARY)
+		channels := routing.Resolve(meta)
+		for _, channel := range notifications.Channels(meta) {
+			if !slices.Contains(channels, channel) {
+				channels = append(slices.Clip(channels), channel)
 			}
-		case "east bay regional park district":
-			channels = append(channels, EAST_BAY_REGIONAL_PARK)
-		case "falck ambulance":
-			channels = append(channels, FALCK_AMBULANCE)
-		case "piedmont":
-			channels = append(channels, PIEDMONT)
-		case "albany":
-			channels = append(channels, ALBANY)
-		case "emeryville":
-			channels = append(channels, EMERYVILLE)
-		case "hayward":
-			channels = append(channels, HAYWARD)
-		case "bart":
-			channels = append(channels, BART)
-		case "us coast guard":
-			channels = append(channels, US_COAST_GUARD, US_COAST_GUARD_SECONDARY)
-		default:
-			log.Printf("Could not resolve channel for talkgroup: %s, %s, %d", meta.TalkGroupGroup, meta.TalkgroupTag, meta.Talkgroup)
 		}
 		return channels
 	}
 )
-
-//slack user id to keywords map
-// supports regex
-
-var notifsMap = map[SlackUserID][]Notifs{
-	EMILIE: []Notifs{
-		{
-			Include:  []string{"kill the beeper", "structure fire", "terminate the incident", "to sage", "to stage", "Tom unit", "10-10", "10-15", "1033", "1033 Frank", "1033F", "1053", "1054", "1055", "1067", "1071", "1071R", "1079", "1180", "1181", "1181P", "1196", "1198", "1199", "20001", "207", "211", "212.5", "215", "220", "243", "243.4", "244", "245", "261", "288", "288A", "415", "451", "accident", "asystole", "asystolic", "beeper", "bike", "bike versus", "BPD", "cage", "catalytic", "challenging", "code 3", "Code 33", "collision", "conscious", "crowd", "DBF", "demo", "detain", "fait", "fate", "fled", "flock", "GOA", "GSW", "gun", "hazmat", "highland", "homicide", "injuries", "loud reports", "PD", "pedestrian", "protest", "pulseless", "register", "responsible", "responsive", "sage", "secure", "shooting", "spikes", "stabbing", "stage", "tased", "UCPD", "versus bike", "versus ped", "weapon", "weapons", "incident terminated", "246", "417", "647i", "bomb", "hate", "LDRPT", "suscir", "susper", "susveh", "rescue", "corner", "coroner"},
-			NotRegex: regexp.MustCompile("no (weapon|gun)s?"),
-			Regex:    versusRegex,
-			Channels: []SlackChannelID{BERKELEY, UCPD},
-		},
-		{
-			Include: []string{"trauma", "trauma activation"},
-		},
-	},
-	NAVEEN: []Notifs{{
-		Include:  []string{"hit and run", "auto ped", "auto-ped", "autoped", "autobike", "auto bicycle", "auto-bike", "auto-bicycle", "Rose St", "Rose Street", "Ruth Acty", "King Middle"},
-		Regex:    versusRegex,
-		Channels: []SlackChannelID{BERKELEY, BERKELEY_SECONDARY, UCPD, ALBANY, EMERYVILLE},
-	}},
-	MARC: []Notifs{{
-		Include:  []string{"hit and run", "autobike", "auto bike", "auto bicycle", "auto bicyclist", "auto ped", "auto-ped", "autoped"},
-		Regex:    versusRegex,
-		Channels: []SlackChannelID{BERKELEY, BERKELEY_SECONDARY, UCPD},
-	}},
-	JOSE: []Notifs{{
-		Include:  []string{"accident", "collision", "crash", "crashed", "crashes"},
-		Regex:    versusRegex,
-		Channels: []SlackChannelID{OAKLAND},
-	}},
-	STEPHAN: []Notifs{{
-		Include:  []string{"GSW", "Active Shooter", "Shots Fired", "Pursuit", "Structure Fire", "Shooting", "Shooter", "Shots", "Code 33", "glock"},
-		NotRegex: regexp.MustCompile("no (weapon|gun)s?"),
-		Channels: []SlackChannelID{BERKELEY, UCPD},
-	}},
-	HELEN: []Notifs{{
-		Include:  []string{"hit and run", "autobike", "auto bike", "auto bicycle", "auto bicyclist", "auto ped", "auto-ped", "autoped", "marin", "hopkins", "el dorado"},
-		Regex:    versusRegex,
-		Channels: []SlackChannelID{BERKELEY},
-	}},
-	TAJ: []Notifs{{
-		Include:  []string{"autobike", "auto bike", "auto bicycle", "auto bicyclist", "auto ped", "auto-ped", "autoped", "Structure Fire", "medic", "engine", "batallion", "truck", "ems", "utility", "prevention"},
-		Regex:    versusRegex,
-		Channels: []SlackChannelID{BERKELEY},
-	}},
-	FORD: []Notifs{{
-		Include:  []string{"autobike", "auto bike", "auto bicycle", "auto bicyclist", "auto ped", "auto-ped", "autoped", "Structure Fire"},
-		Regex:    versusRegex,
-		Channels: []SlackChannelID{BERKELEY},
-	}}, 
-}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// bearer token guarding the /admin endpoints. The endpoints are disabled when it is unset.
+var adminToken string = os.Getenv("ADMIN_TOKEN")
+
+// DeadLetter records a request whose step failed after exhausting its retry policy
+type DeadLetter struct {
+	ID        int64                 `json:"id"`
+	Step      string                `json:"step"`
+	Error     string                `json:"error"`
+	CreatedAt time.Time             `json:"created_at"`
+	Request   *TranscriptionRequest `json:"request,omitempty"`
+}
+
+// DeadLetters stores failed steps in sqlite so they can be listed and replayed
+type DeadLetters struct {
+	db *sql.DB
+}
+
+func NewDeadLetters(ctx context.Context, db *sql.DB) (*DeadLetters, error) {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS dead_letters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			step TEXT NOT NULL,
+			error TEXT NOT NULL,
+			request BLOB NOT NULL,
+			created_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetters{db: db}, nil
+}
+
+// Add records that step failed for req. The request is stored as it was when the step failed, so
+// a replay does not need to redo the steps that succeeded.
+func (d *DeadLetters) Add(ctx context.Context, req *TranscriptionRequest, step string, stepErr error) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, `INSERT INTO dead_letters (step, error, request, created_at) VALUES (?, ?, ?, ?)`,
+		step, stepErr.Error(), b, time.Now().Unix())
+	return err
+}
+
+// List returns the dead letters, newest first, without their audio
+func (d *DeadLetters) List(ctx context.Context) ([]DeadLetter, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT id, step, error, request, created_at FROM dead_letters ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letter.Request.Data = nil
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+// Take removes the dead letter and returns it
+func (d *DeadLetters) Take(ctx context.Context, id int64) (DeadLetter, error) {
+	row := d.db.QueryRowContext(ctx, `DELETE FROM dead_letters WHERE id = ? RETURNING id, step, error, request, created_at`, id)
+	return scanDeadLetter(row)
+}
+
+func scanDeadLetter(row interface{ Scan(...any) error }) (letter DeadLetter, err error) {
+	var b []byte
+	var created int64
+	if err = row.Scan(&letter.ID, &letter.Step, &letter.Error, &b, &created); err != nil {
+		return letter, err
+	}
+	letter.CreatedAt = time.Unix(created, 0).In(location)
+	err = json.Unmarshal(b, &letter.Request)
+	return letter, err
+}
+
+// deadLetter records the failed step, or just logs it if there is no dead letter store
+func deadLetter(ctx context.Context, config *Config, req *TranscriptionRequest, step string, err error) {
+	log.Printf("[deadLetter] %s failed for %s: %v", step, req.Filename, err)
+	if config == nil || config.deadLetters == nil {
+		return
+	}
+
+	failed := *req
+	failed.Steps = []string{step}
+	if step == stepTranscribe {
+		// a new transcript has to be archived and posted too
+		failed.Steps = append(failed.Steps, stepR2, stepSlack, stepNotify)
+	}
+	if err := config.deadLetters.Add(context.WithoutCancel(ctx), &failed, step, err); err != nil {
+		log.Printf("[deadLetter] Error recording dead letter for %s: %v", req.Filename, err)
+	}
+}
+
+// requireAdmin wraps h so it is only served to requests bearing ADMIN_TOKEN
+func requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := []byte("Bearer " + adminToken)
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleListDeadLetters serves GET /admin/dead-letters
+func handleListDeadLetters(config *Config) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		letters, err := config.deadLetters.List(r.Context())
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(letters)
+	})
+}
+
+// handleReplayDeadLetter serves POST /admin/dead-letters/{id}/replay. The failed step is queued
+// again on its own.
+func handleReplayDeadLetter(config *Config, queue *Queue) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid dead letter id", http.StatusBadRequest)
+			return
+		}
+
+		letter, err := config.deadLetters.Take(r.Context(), id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			writeErr(w, err)
+			return
+		}
+
+		if !enqueue(w, r, queue, letter.Request) {
+			// put it back so it is not lost
+			if err := config.deadLetters.Add(r.Context(), letter.Request, letter.Step, errors.New(letter.Error)); err != nil {
+				log.Printf("[deadLetter] Error restoring dead letter %d: %v", id, err)
+			}
+			return
+		}
+		log.Printf("[deadLetter] Replaying %s for %s", letter.Step, letter.Request.Filename)
+		writeOK(w, r)
+	})
+}
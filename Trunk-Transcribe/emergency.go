@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// slack user ids in the primary workspace notified of every emergency call, comma separated
+var emergencyOnCall []SlackUserID = parseOnCall(os.Getenv("EMERGENCY_ONCALL"))
+
+// queue priorities. Higher priorities are claimed first.
+const (
+	queuePriorityNormal    = 0
+	queuePriorityEmergency = 1
+)
+
+func parseOnCall(value string) (users []SlackUserID) {
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			users = append(users, SlackUserID(id))
+		}
+	}
+	return users
+}
+
+// IsEmergency returns whether the call or any unit on it has the emergency bit set
+func (meta Metadata) IsEmergency() bool {
+	return meta.Emergency != 0 || len(meta.EmergencyUnits()) > 0
+}
+
+// EmergencyUnits returns the units that signalled an emergency during the call
+func (meta Metadata) EmergencyUnits() (units []Source) {
+	for _, src := range meta.SrcList {
+		if src.Emergency != 0 && !slices.ContainsFunc(units, func(u Source) bool { return u.Src == src.Src }) {
+			units = append(units, src)
+		}
+	}
+	return units
+}
+
+// queuePriority returns the priority the request is claimed with
+func queuePriority(req *TranscriptionRequest) int {
+	if req.Meta.IsEmergency() {
+		return queuePriorityEmergency
+	}
+	return queuePriorityNormal
+}
+
+// emergencyBanner is the first line of an emergency call's slack post
+func emergencyBanner(meta Metadata) string {
+	banner := ":rotating_light: *EMERGENCY* :rotating_light:"
+	var units []string
+	for _, unit := range meta.EmergencyUnits() {
+		if unit.Tag != "" {
+			units = append(units, fmt.Sprintf("%d (%s)", unit.Src, unit.Tag))
+		} else {
+			units = append(units, fmt.Sprint(unit.Src))
+		}
+	}
+	if len(units) > 0 {
+		banner += " activated by " + strings.Join(units, ", ")
+	}
+	return banner
+}
+
+// onCallMentions returns the on-call users to mention in the channel for an emergency call, other
+// than those already mentioned
+func onCallMentions(meta Metadata, channelID SlackChannelID, mentions []string) []string {
+	if !meta.IsEmergency() || !slices.Contains(PRIMARY_CHANNELS, channelID) {
+		return mentions
+	}
+	for _, userID := range emergencyOnCall {
+		if mention := "<@" + string(userID) + ">"; !slices.Contains(mentions, mention) {
+			mentions = append(mentions, mention)
+		}
+	}
+	return mentions
+}
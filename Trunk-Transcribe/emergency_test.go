@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmergencyCall(t *testing.T) {
+	defer func(onCall []SlackUserID) { emergencyOnCall = onCall }(emergencyOnCall)
+	emergencyOnCall = parseOnCall(" U0ONCALL1, U0ONCALL2,")
+
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+	assert.False(t, meta.IsEmergency())
+	assert.Equal(t, []string(nil), ExtractSlackMeta(meta, BERKELEY, nil).Mentions)
+
+	meta.SrcList[1].Emergency = 1
+	assert.True(t, meta.IsEmergency())
+	assert.Equal(t, ":rotating_light: *EMERGENCY* :rotating_light: activated by 3113008 (Dispatch)", emergencyBanner(meta))
+
+	notifs := map[SlackUserID][]Notifs{"U0ONCALL1": {{Include: []string{"durant"}, Channels: []SlackChannelID{BERKELEY}}}}
+	meta.AudioText = "2605 Durant"
+	assert.Equal(t, []string{"<@U0ONCALL1>", "<@U0ONCALL2>"}, ExtractSlackMeta(meta, BERKELEY, notifs).Mentions)
+
+	// on-call users are in the primary workspace
+	assert.Equal(t, []string(nil), ExtractSlackMeta(meta, SlackChannelID("C0SECONDARY"), nil).Mentions)
+}
+
+func TestRdioEmergency(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    map[string]string
+		emergency bool
+	}{
+		{
+			name:   "routine",
+			fields: map[string]string{"sources": `[{"pos": 0, "src": 3113008, "tag": "Dispatch"}]`},
+		},
+		{
+			name:      "call emergency",
+			fields:    map[string]string{"emergency": "1", "sources": `[{"pos": 0, "src": 3113008}]`},
+			emergency: true,
+		},
+		{
+			name:      "unit emergency",
+			fields:    map[string]string{"sources": `[{"pos": 0, "src": 3113008}, {"pos": 2, "src": 3124119, "emergency": true}]`},
+			emergency: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			audio, _ := writer.CreateFormFile("audio", "call.m4a")
+			audio.Write(bytes.Repeat([]byte{0}, 64))
+			fields := map[string]string{"key": "1", "dateTime": "1702617247", "system": "1", "talkgroup": "3405", "talkgroupName": "Oakland"}
+			for k, v := range test.fields {
+				fields[k] = v
+			}
+			for k, v := range fields {
+				writer.WriteField(k, v)
+			}
+			writer.Close()
+
+			r := httptest.NewRequest("POST", "/transcribe/api/call-upload", body)
+			r.Header.Set("Content-Type", writer.FormDataContentType())
+			req, err := createTranscriptionRequestFromRdio(context.Background(), nil, r)
+			require.NoError(t, err)
+			assert.Equal(t, test.emergency, req.Meta.IsEmergency())
+			assert.Equal(t, test.emergency, queuePriority(req) == queuePriorityEmergency)
+		})
+	}
+}
+
+func TestEmergencyBypassesDedupe(t *testing.T) {
+	var meta Metadata
+	require.NoError(t, json.NewDecoder(strings.NewReader(data)).Decode(&meta))
+	meta.StartTime = 1702000000 // unique to this test in the dedupe cache
+	assert.False(t, dedupeDispatch(meta))
+	assert.True(t, dedupeDispatch(meta))
+
+	meta.Emergency = 1
+	assert.False(t, dedupeDispatch(meta))
+	assert.False(t, dedupeDispatch(meta))
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// enhanceConfigPath optionally points at an enhancement pipeline file that replaces the embedded
+// default
+var enhanceConfigPath string = os.Getenv("ENHANCE_CONFIG")
+
+//go:embed config/enhance.json
+var defaultEnhanceConfig []byte
+
+// audio enhancement stages
+const (
+	stageSilence    = "silence"    // trims leading silence, see Enhance for the transcript timeline
+	stageDeepFilter = "deepfilter" // DeepFilterNet noise suppression, expects wav input
+	stageLoudnorm   = "loudnorm"   // EBU R128 loudness normalization
+	stageBandpass   = "bandpass"   // cuts frequencies outside the voice band
+	stageResample   = "resample"   // converts to mono at the sample rate whisper expects
+)
+
+// stage defaults
+const (
+	defaultStageTimeout     = 5 * time.Second
+	defaultSilenceThreshold = -50   // dB
+	defaultLoudness         = -16   // LUFS
+	defaultBandpassLow      = 300   // Hz
+	defaultBandpassHigh     = 3400  // Hz
+	defaultSampleRate       = 16000 // Hz
+)
+
+// Structures to parse enhancement pipeline json of the form:
+//
+//	{
+//	  "timeout": "5s",
+//	  "default": [{"stage": "silence"}, {"stage": "resample"}],
+//	  "systems": {"Oakland": [{"stage": "bandpass", "low": 250}, {"stage": "loudnorm"}, {"stage": "resample"}]},
+//	  "talkgroups": {"3105": [{"stage": "resample"}, {"stage": "deepfilter", "timeout": "20s"}]}
+//	}
+//
+// Each selection is a chain of stages run in order, each on the previous stage's output. A stage
+// that fails or runs past its timeout is skipped, passing its input on to the next stage. The
+// selection rules match the transcriber config: talkgroup, then system (short_name), then default.
+// Enhanced audio is only transcribed; the original is archived, posted and sent to rdio.
+type EnhanceConfig struct {
+	Timeout    Duration                  `json:"timeout,omitempty"`
+	Default    []EnhanceStage            `json:"default"`
+	Systems    map[string][]EnhanceStage `json:"systems,omitempty"`
+	Talkgroups map[int64][]EnhanceStage  `json:"talkgroups,omitempty"`
+}
+
+// EnhanceStage is a single step of the enhancement pipeline. Unset parameters use the stage
+// defaults.
+type EnhanceStage struct {
+	Stage      string   `json:"stage"`
+	Timeout    Duration `json:"timeout,omitempty"`     // overrides the pipeline timeout
+	Threshold  float64  `json:"threshold,omitempty"`   // silence: level in dB below which audio is silent
+	Loudness   float64  `json:"loudness,omitempty"`    // loudnorm: integrated loudness target in LUFS
+	Low        int      `json:"low,omitempty"`         // bandpass: lowest frequency kept in Hz
+	High       int      `json:"high,omitempty"`        // bandpass: highest frequency kept in Hz
+	SampleRate int      `json:"sample_rate,omitempty"` // resample: output sample rate in Hz
+}
+
+// Enhancer selects and runs the enhancement pipeline for a call
+type Enhancer struct {
+	config EnhanceConfig
+}
+
+// NewEnhancer validates the stages of every pipeline in the config
+func NewEnhancer(config EnhanceConfig) (*Enhancer, error) {
+	var errs []error
+	check := func(where string, stages []EnhanceStage) {
+		for i, stage := range stages {
+			if err := stage.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s stage %d: %w", where, i, err))
+			}
+		}
+	}
+	check("default", config.Default)
+	for system, stages := range config.Systems {
+		check("system "+system, stages)
+	}
+	for tg, stages := range config.Talkgroups {
+		check(fmt.Sprintf("talkgroup %d", tg), stages)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &Enhancer{config: config}, nil
+}
+
+// ParseEnhanceConfig parses enhancement pipeline json
+func ParseEnhanceConfig(b []byte) (config EnhanceConfig, err error) {
+	err = json.Unmarshal(b, &config)
+	return config, err
+}
+
+// LoadEnhanceConfig reads the pipeline file at path, or the embedded default if path is empty
+func LoadEnhanceConfig(path string) (EnhanceConfig, error) {
+	if path == "" {
+		return ParseEnhanceConfig(defaultEnhanceConfig)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return EnhanceConfig{}, err
+	}
+	config, err := ParseEnhanceConfig(b)
+	if err != nil {
+		return config, fmt.Errorf("enhance config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// For returns the stages selected for the call described by meta
+func (e *Enhancer) For(meta Metadata) []EnhanceStage {
+	if stages, ok := e.config.Talkgroups[meta.Talkgroup]; ok {
+		return stages
+	}
+	for system, stages := range e.config.Systems {
+		if strings.EqualFold(system, meta.ShortName) {
+			return stages
+		}
+	}
+	return e.config.Default
+}
+
+// Enhance runs the call's pipeline over the audio, returning the output of the last stage that
+// succeeded and the seconds of leading audio the silence stage trimmed. Transcript timestamps of
+// the enhanced audio are that much earlier than the srcList positions of the original. A nil
+// Enhancer returns the audio unchanged.
+func (e *Enhancer) Enhance(ctx context.Context, meta Metadata, data []byte) ([]byte, float64) {
+	if e == nil {
+		return data, 0
+	}
+	var trimmed float64
+	for _, stage := range e.For(meta) {
+		timeout := time.Duration(cmp.Or(stage.Timeout, e.config.Timeout, Duration(defaultStageTimeout)))
+		stageCtx, cancel := context.WithTimeout(ctx, timeout)
+		out, cut, err := stage.run(stageCtx, data)
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return data, trimmed
+		case err != nil:
+			log.Printf("[enhance] %s failed, skipping: %v", stage.Stage, err)
+		case len(out) == 0:
+			log.Printf("[enhance] %s returned no audio, skipping", stage.Stage)
+		default:
+			data = out
+			trimmed += cut
+		}
+	}
+	return data, trimmed
+}
+
+// run applies the stage like Run, also returning the seconds of leading audio it trimmed. Only
+// the silence stage trims, it is measured on the audio decoded to wav.
+func (s EnhanceStage) run(ctx context.Context, data []byte) ([]byte, float64, error) {
+	if s.Stage != stageSilence {
+		out, err := s.Run(ctx, data)
+		return out, 0, err
+	}
+
+	before, ok := wavDuration(data)
+	if !ok {
+		wav, err := ffmpegFilter(ctx, data, "anull")
+		if err != nil {
+			return nil, 0, err
+		}
+		data = wav
+		before, ok = wavDuration(data)
+	}
+	out, err := s.Run(ctx, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	after, outOK := wavDuration(out)
+	if !ok || !outOK || after > before {
+		return out, 0, nil
+	}
+	return out, before - after, nil
+}
+
+func (s EnhanceStage) validate() error {
+	switch s.Stage {
+	case stageSilence, stageDeepFilter, stageLoudnorm, stageResample:
+		return nil
+	case stageBandpass:
+		if low, high := s.bandpass(); low >= high {
+			return fmt.Errorf("bandpass low %d Hz is not below high %d Hz", low, high)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown stage %q", s.Stage)
+}
+
+func (s EnhanceStage) bandpass() (low, high int) {
+	return cmp.Or(s.Low, defaultBandpassLow), cmp.Or(s.High, defaultBandpassHigh)
+}
+
+// filter returns the ffmpeg audio filter implementing the stage
+func (s EnhanceStage) filter() string {
+	switch s.Stage {
+	case stageSilence:
+		return fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%gdB", cmp.Or(s.Threshold, float64(defaultSilenceThreshold)))
+	case stageLoudnorm:
+		return fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11", cmp.Or(s.Loudness, float64(defaultLoudness)))
+	case stageBandpass:
+		low, high := s.bandpass()
+		return fmt.Sprintf("highpass=f=%d,lowpass=f=%d", low, high)
+	case stageResample:
+		return fmt.Sprintf("aformat=sample_rates=%d:channel_layouts=mono", cmp.Or(s.SampleRate, defaultSampleRate))
+	}
+	return ""
+}
+
+// Run applies the stage to the audio, returning wav
+func (s EnhanceStage) Run(ctx context.Context, data []byte) ([]byte, error) {
+	if s.Stage == stageDeepFilter {
+		return deepFilter(ctx, data)
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return ffmpegFilter(ctx, data, s.filter())
+}
+
+// ffmpegFilter runs the audio through the ffmpeg audio filter, returning wav
+func ffmpegFilter(ctx context.Context, data []byte, filter string) ([]byte, error) {
+	var out, stderr bytes.Buffer
+	stream := ffmpeg.Input("pipe:")
+	stream.Context = ctx
+	err := stream.
+		WithInput(bytes.NewReader(data)).
+		Output("pipe:", ffmpeg.KwArgs{
+			"af":          filter,
+			"format":      "wav",
+			"hide_banner": "",
+			"loglevel":    "error",
+		}).
+		WithOutput(&out, &stderr).
+		Silent(true).
+		Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out.Bytes(), nil
+}
+
+// wavDuration returns the seconds of audio in wav data. ffmpeg writing to a pipe cannot fill in
+// the size of the data chunk, so without one it runs to the end of the data.
+func wavDuration(b []byte) (float64, bool) {
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return 0, false
+	}
+	var byteRate uint32
+	for i := 12; i+8 <= len(b); {
+		id, size, body := string(b[i:i+4]), int(binary.LittleEndian.Uint32(b[i+4:i+8])), i+8
+		switch id {
+		case "fmt ":
+			if body+12 > len(b) {
+				return 0, false
+			}
+			byteRate = binary.LittleEndian.Uint32(b[body+8 : body+12])
+		case "data":
+			if byteRate == 0 {
+				return 0, false
+			}
+			n := len(b) - body
+			if size > 0 && size < n {
+				n = size
+			}
+			return float64(n) / float64(byteRate), true
+		}
+		i = body + size + size%2
+	}
+	return 0, false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnhanceSelection(t *testing.T) {
+	config, err := ParseEnhanceConfig([]byte(`{
+		"timeout": "2s",
+		"default": [{"stage": "silence"}, {"stage": "resample"}],
+		"systems": {"oakland": [{"stage": "bandpass", "low": 250}, {"stage": "loudnorm"}]},
+		"talkgroups": {"3105": [{"stage": "deepfilter", "timeout": "20s"}]}
+	}`))
+	require.NoError(t, err)
+
+	enhancer, err := NewEnhancer(config)
+	require.NoError(t, err)
+
+	stages := func(meta Metadata) (names []string) {
+		for _, stage := range enhancer.For(meta) {
+			names = append(names, stage.Stage)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"deepfilter"}, stages(Metadata{Talkgroup: 3105, ShortName: "Oakland"}))
+	assert.Equal(t, []string{"bandpass", "loudnorm"}, stages(Metadata{Talkgroup: 3405, ShortName: "Oakland"}))
+	assert.Equal(t, []string{"silence", "resample"}, stages(Metadata{Talkgroup: 2105, ShortName: "Berkeley"}))
+	assert.Equal(t, "highpass=f=250,lowpass=f=3400", config.Systems["oakland"][0].filter())
+
+	config.Talkgroups[2105] = []EnhanceStage{{Stage: "bandpass", Low: 4000}, {Stage: "denoise"}}
+	_, err = NewEnhancer(config)
+	assert.ErrorContains(t, err, "talkgroup 2105 stage 0: bandpass low 4000 Hz is not below high 3400 Hz")
+	assert.ErrorContains(t, err, `talkgroup 2105 stage 1: unknown stage "denoise"`)
+
+	_, err = LoadEnhanceConfig("")
+	assert.NoError(t, err)
+}
+
+func TestEnhanceFallback(t *testing.T) {
+	ctx := context.Background()
+	wav, err := base64.StdEncoding.DecodeString(silenceAudio)
+	require.NoError(t, err)
+
+	t.Run("failing stages pass audio through", func(t *testing.T) {
+		enhancer, err := NewEnhancer(EnhanceConfig{Default: []EnhanceStage{
+			{Stage: stageResample, Timeout: Duration(time.Nanosecond)},
+			{Stage: stageDeepFilter},
+		}})
+		require.NoError(t, err)
+		enhanced, trimmed := enhancer.Enhance(ctx, Metadata{}, wav)
+		assert.Equal(t, wav, enhanced)
+		assert.Zero(t, trimmed)
+	})
+
+	t.Run("nil enhancer", func(t *testing.T) {
+		var enhancer *Enhancer
+		enhanced, _ := enhancer.Enhance(ctx, Metadata{}, wav)
+		assert.Equal(t, wav, enhanced)
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			t.Skip("ffmpeg not installed")
+		}
+		enhancer, err := NewEnhancer(EnhanceConfig{Default: []EnhanceStage{
+			{Stage: stageSilence},
+			{Stage: stageDeepFilter},
+			{Stage: stageResample, SampleRate: 8000},
+		}})
+		require.NoError(t, err)
+
+		enhanced, _ := enhancer.Enhance(ctx, Metadata{}, wav)
+		assert.Equal(t, "RIFF", string(enhanced[:4]))
+		assert.Less(t, len(enhanced), len(wav))
+	})
+}
+
+// testWav returns 8kHz 16-bit mono wav of leading silence followed by a square wave tone
+func testWav(silence, tone time.Duration) []byte {
+	const rate = 8000
+	samples := make([]int16, int(silence.Seconds()*rate))
+	for i := 0; i < int(tone.Seconds()*rate); i++ {
+		samples = append(samples, int16(8000-16000*(i/10%2)))
+	}
+
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	b.WriteString("RIFF")
+	binary.Write(&b, le, uint32(36+2*len(samples)))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, le, struct {
+		Size                      uint32
+		Format, Channels          uint16
+		SampleRate, ByteRate      uint32
+		BlockAlign, BitsPerSample uint16
+	}{16, 1, 1, rate, 2 * rate, 2, 16})
+	b.WriteString("data")
+	binary.Write(&b, le, uint32(2*len(samples)))
+	binary.Write(&b, le, samples)
+	return b.Bytes()
+}
+
+func TestWavDuration(t *testing.T) {
+	wav := testWav(time.Second, 500*time.Millisecond)
+	duration, ok := wavDuration(wav)
+	require.True(t, ok)
+	assert.Equal(t, 1.5, duration)
+
+	// ffmpeg writing to a pipe leaves the data size unset
+	unsized := bytes.Clone(wav)
+	binary.LittleEndian.PutUint32(unsized[40:44], 0)
+	duration, ok = wavDuration(unsized)
+	require.True(t, ok)
+	assert.Equal(t, 1.5, duration)
+
+	_, ok = wavDuration([]byte("ID3 not a wav"))
+	assert.False(t, ok)
+}
+
+func TestEnhanceTrimmed(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	enhancer, err := NewEnhancer(EnhanceConfig{Default: []EnhanceStage{{Stage: stageSilence}, {Stage: stageResample}}})
+	require.NoError(t, err)
+
+	enhanced, trimmed := enhancer.Enhance(context.Background(), Metadata{}, testWav(time.Second, 500*time.Millisecond))
+	assert.InDelta(t, 1.0, trimmed, 0.05)
+	duration, ok := wavDuration(enhanced)
+	require.True(t, ok)
+	assert.InDelta(t, 0.5, duration, 0.05)
+}
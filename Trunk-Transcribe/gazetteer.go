@@ -0,0 +1,374 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// gazetteerDir optionally points at a directory of gazetteer files that replaces the embedded
+// default
+var gazetteerDir string = os.Getenv("GAZETTEER_DIR")
+
+//go:embed config/gazetteer
+var defaultGazetteerFiles embed.FS
+
+var gazetteer = mustParseGazetteer(defaultGazetteerFiles, "config/gazetteer")
+
+// Structures to parse a city's gazetteer json of the form:
+//
+//	{
+//	  "city": "Berkeley",
+//	  "agencies": ["Berkeley", "BPD", "BFD"],
+//	  "streets": [
+//	    ["Martin Luther King Jr", "Martin Luther King", "MLK"],
+//	    ["Shattuck"]
+//	  ]
+//	}
+//
+// Each street lists its canonical name followed by any aliases. Names may have several words, and
+// a trailing suffix such as "Ave" is matched without being listed. Agencies are the talkgroup
+// groups and systems whose calls are in the city.
+type GazetteerCity struct {
+	City     string     `json:"city"`
+	Agencies []string   `json:"agencies,omitempty"`
+	Streets  [][]string `json:"streets"`
+}
+
+// streetRef is the canonical name of a street in a city
+type streetRef struct {
+	city string
+	name string
+}
+
+// gazetteerPhrase is a lowercase street name or alias and the streets it refers to
+type gazetteerPhrase struct {
+	words int
+	refs  []streetRef
+}
+
+// Gazetteer finds street names in transcripts, tolerating the misspellings Whisper makes of them
+type Gazetteer struct {
+	cities   []GazetteerCity
+	phrases  map[string]*gazetteerPhrase
+	maxWords int
+}
+
+func mustParseGazetteer(fsys fs.FS, dir string) *Gazetteer {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	g, err := ParseGazetteer(sub)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// LoadGazetteer reads the gazetteer files in dir
+func LoadGazetteer(dir string) (*Gazetteer, error) {
+	g, err := ParseGazetteer(os.DirFS(dir))
+	if err != nil {
+		return nil, fmt.Errorf("gazetteer %s: %w", dir, err)
+	}
+	return g, nil
+}
+
+// ParseGazetteer parses every *.json file in fsys as a city's gazetteer
+func ParseGazetteer(fsys fs.FS) (*Gazetteer, error) {
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no gazetteer files")
+	}
+
+	g := &Gazetteer{phrases: map[string]*gazetteerPhrase{}}
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var city GazetteerCity
+		if err := json.Unmarshal(b, &city); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if city.City == "" {
+			return nil, fmt.Errorf("%s: missing city", name)
+		}
+		g.cities = append(g.cities, city)
+
+		for i, names := range city.Streets {
+			if len(names) == 0 {
+				return nil, fmt.Errorf("%s: street %d has no name", path.Base(name), i)
+			}
+			for _, alias := range names {
+				words := wordsRegex.FindAllString(strings.ToLower(alias), -1)
+				if len(words) == 0 {
+					return nil, fmt.Errorf("%s: invalid street name %q", path.Base(name), alias)
+				}
+				g.add(strings.Join(words, " "), len(words), streetRef{city: city.City, name: names[0]})
+			}
+		}
+	}
+	return g, nil
+}
+
+func (g *Gazetteer) add(phrase string, words int, ref streetRef) {
+	p, ok := g.phrases[phrase]
+	if !ok {
+		p = &gazetteerPhrase{words: words}
+		g.phrases[phrase] = p
+	}
+	if !slices.Contains(p.refs, ref) {
+		p.refs = append(p.refs, ref)
+	}
+	g.maxWords = max(g.maxWords, words)
+}
+
+// Streets returns the names and aliases of the city's streets, for prompting transcription
+func (g *Gazetteer) Streets(city string) (streets []string) {
+	if g == nil {
+		return nil
+	}
+	for _, c := range g.cities {
+		if strings.EqualFold(c.City, city) {
+			for _, names := range c.Streets {
+				streets = append(streets, names...)
+			}
+		}
+	}
+	return streets
+}
+
+// citiesFor returns the cities the call may be in, from the agencies matching its talkgroup group
+// or, failing that, its system. Every city is returned if neither matches.
+func (g *Gazetteer) citiesFor(meta Metadata) []string {
+	for _, name := range []string{meta.TalkGroupGroup, meta.ShortName} {
+		name = strings.ToLower(name)
+		if name == "" {
+			continue
+		}
+		var cities []string
+		for _, city := range g.cities {
+			for _, agency := range append([]string{city.City}, city.Agencies...) {
+				if strings.Contains(name, strings.ToLower(agency)) {
+					cities = append(cities, city.City)
+					break
+				}
+			}
+		}
+		if len(cities) > 0 {
+			return cities
+		}
+	}
+
+	cities := make([]string, len(g.cities))
+	for i, city := range g.cities {
+		cities[i] = city.City
+	}
+	return cities
+}
+
+// streetMatch is a street found in words[start:end]
+type streetMatch struct {
+	start, end int
+	name       string
+	cities     []string
+	exact      bool
+}
+
+// resolve returns the street the phrase refers to in the cities, preferring the first city's name
+// when an alias has different canonical names
+func (p *gazetteerPhrase) resolve(cities []string) (name string, in []string) {
+	for _, city := range cities {
+		for _, ref := range p.refs {
+			if ref.city == city && (name == "" || ref.name == name) {
+				name = ref.name
+				in = append(in, city)
+			}
+		}
+	}
+	return name, in
+}
+
+// Extract finds the address in the transcript of the call. Street names are matched exactly
+// first, then with a few typos where the word is next to an address number, a street suffix or
+// "and" another street. Only the streets of the cities the call may be in are matched. A number
+// directly before a street makes the primary address, further streets are cross streets. The
+// city is set if the streets found are all in one city.
+func (g *Gazetteer) Extract(meta Metadata, text string) (addr Address) {
+	if g == nil {
+		return addr
+	}
+	cities := g.citiesFor(meta)
+	words := wordsRegex.FindAllString(strings.ToLower(text), -1)
+
+	// owner is the match covering each word
+	owner := make([]*streetMatch, len(words))
+	claim := func(m *streetMatch) {
+		if m.end < len(words) && isStreetSuffix(words[m.end]) {
+			m.end++
+		}
+		for i := m.start; i < m.end; i++ {
+			owner[i] = m
+		}
+	}
+
+	for i := 0; i < len(words); i++ {
+		if m := g.matchExact(words, i, cities); m != nil {
+			claim(m)
+			i = m.end - 1
+		}
+	}
+	for i := 0; i < len(words); i++ {
+		if owner[i] != nil {
+			continue
+		}
+		if m := g.matchFuzzy(words, owner, i, cities); m != nil {
+			claim(m)
+			i = m.end - 1
+		}
+	}
+
+	common := cities
+	var last *streetMatch
+	for i, m := range owner {
+		if m == nil || m == last {
+			continue
+		}
+		last = m
+		common = slices.DeleteFunc(slices.Clone(common), func(city string) bool {
+			return !slices.Contains(m.cities, city)
+		})
+
+		if i > 0 && isNumber(words[i-1]) && addr.PrimaryAddress == "" {
+			addr.PrimaryAddress = words[i-1] + " " + m.name
+			continue
+		}
+		addr = addr.AppendStreet(m.name)
+	}
+	if last != nil && len(common) == 1 {
+		addr.City = common[0]
+	}
+	return addr
+}
+
+// matchExact matches the longest street name starting at words[i]
+func (g *Gazetteer) matchExact(words []string, i int, cities []string) *streetMatch {
+	for n := min(g.maxWords, len(words)-i); n > 0; n-- {
+		p, ok := g.phrases[strings.Join(words[i:i+n], " ")]
+		if !ok || p.words != n {
+			continue
+		}
+		if name, in := p.resolve(cities); name != "" {
+			return &streetMatch{start: i, end: i + n, name: name, cities: in, exact: true}
+		}
+	}
+	return nil
+}
+
+// matchFuzzy matches the closest street name starting at words[i] within the typos its length
+// allows. An equally close match to another street makes the words ambiguous.
+func (g *Gazetteer) matchFuzzy(words []string, owner []*streetMatch, i int, cities []string) *streetMatch {
+	for n := min(g.maxWords, len(words)-i); n > 0; n-- {
+		if slices.ContainsFunc(owner[i:i+n], func(m *streetMatch) bool { return m != nil }) {
+			continue
+		}
+		candidate := strings.Join(words[i:i+n], " ")
+		if strings.ContainsAny(candidate, "0123456789") || !inAddressContext(words, owner, i, i+n) {
+			continue
+		}
+
+		var best *streetMatch
+		bestDistance, ambiguous := 0, false
+		for phrase, p := range g.phrases {
+			if p.words != n {
+				continue
+			}
+			distance := editDistance(candidate, phrase)
+			if distance == 0 || distance > maxEdits(max(len(candidate), len(phrase))-n+1) {
+				continue
+			}
+			name, in := p.resolve(cities)
+			switch {
+			case name == "":
+			case best == nil || distance < bestDistance:
+				best, bestDistance, ambiguous = &streetMatch{start: i, end: i + n, name: name, cities: in}, distance, false
+			case distance == bestDistance && name != best.name:
+				ambiguous = true
+			}
+		}
+		if best != nil && !ambiguous {
+			return best
+		}
+	}
+	return nil
+}
+
+// inAddressContext reports whether words[start:end] are where a street name is expected: after
+// an address number, before a street suffix, or joined by "and" to a street matched exactly
+func inAddressContext(words []string, owner []*streetMatch, start, end int) bool {
+	exact := func(i int) bool {
+		return i >= 0 && i < len(owner) && owner[i] != nil && owner[i].exact
+	}
+	switch {
+	case start > 0 && isNumber(words[start-1]):
+		return true
+	case end < len(words) && isStreetSuffix(words[end]):
+		return true
+	case start > 1 && words[start-1] == "and" && exact(start-2):
+		return true
+	case end+1 < len(words) && words[end] == "and" && exact(end+1):
+		return true
+	}
+	return false
+}
+
+// maxEdits is how many typos a street name may have and still be recognized, where n is the
+// number of letters in the longer of the name and the transcribed words
+func maxEdits(n int) int {
+	switch {
+	case n >= 8:
+		return 2
+	case n >= 5:
+		return 1
+	}
+	return 0
+}
+
+func isStreetSuffix(word string) bool {
+	return slices.Contains(streetSuffixes, word) || slices.Contains(modifiers, word)
+}
+
+func isNumber(word string) bool {
+	return word != "" && strings.Trim(word, "0123456789") == ""
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
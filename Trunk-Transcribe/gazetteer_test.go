@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGazetteerExtract(t *testing.T) {
+	berkeley := Metadata{TalkGroupGroup: "Berkeley", ShortName: "Berkeley"}
+	oakland := Metadata{TalkGroupGroup: "Oakland Police", ShortName: "EBRCSA"}
+	unknown := Metadata{ShortName: "EBRCSA"}
+
+	tests := []struct {
+		name   string
+		meta   Metadata
+		text   string
+		expect Address
+	}{
+		{
+			name: "no address",
+			meta: berkeley,
+			text: "Can you tell me one more time? you got me en route to 1071 in the SRT van",
+		},
+		{
+			name:   "address number",
+			meta:   berkeley,
+			text:   "Berkeley, 2605 Durant. Copy.",
+			expect: Address{City: "Berkeley", PrimaryAddress: "2605 Durant"},
+		},
+		{
+			name:   "cross streets",
+			meta:   berkeley,
+			text:   "In the SRT van at Bancroft between Channing and Milvia",
+			expect: Address{City: "Berkeley", Streets: []string{"Bancroft", "Channing", "Milvia"}},
+		},
+		{
+			name:   "misspelled cross street",
+			meta:   berkeley,
+			text:   "Fancroft and Piedmont,we've got a vehicle versus bike, and we've got an involved party on the phone,we've got BFD and RUN as well",
+			expect: Address{City: "Berkeley", Streets: []string{"Bancroft", "Piedmont"}},
+		},
+		{
+			name:   "misspelled street in any city",
+			meta:   unknown,
+			text:   "Copy, just confirming southeast corner, Alcatraz and Adelaide. Confirming, it's in the parking lot, it's on the sidewalk, it's out of the roadway",
+			expect: Address{Streets: []string{"Alcatraz", "Adeline"}},
+		},
+		{
+			name:   "misspelled street after address number",
+			meta:   berkeley,
+			text:   "Engine 2 respond to 1900 Shatuck for a fall",
+			expect: Address{City: "Berkeley", PrimaryAddress: "1900 Shattuck"},
+		},
+		{
+			name:   "misspelled street before suffix",
+			meta:   berkeley,
+			text:   "Subject last seen on Telegrah Avenue heading south",
+			expect: Address{City: "Berkeley", Streets: []string{"Telegraph"}},
+		},
+		{
+			name: "misspelling needs address context",
+			meta: berkeley,
+			text: "Fancroft is what he said his name was",
+		},
+		{
+			name:   "multi word street",
+			meta:   berkeley,
+			text:   "Units responding to San Pablo and Gilman, vehicle versus bicycle",
+			expect: Address{City: "Berkeley", Streets: []string{"San Pablo", "Gilman"}},
+		},
+		{
+			name:   "alias",
+			meta:   berkeley,
+			text:   "Car versus ped at MLK and Hearst",
+			expect: Address{City: "Berkeley", Streets: []string{"Martin Luther King Jr", "Hearst"}},
+		},
+		{
+			name:   "longest alias with suffix",
+			meta:   berkeley,
+			text:   "1800 Martin Luther King Way, second floor",
+			expect: Address{City: "Berkeley", PrimaryAddress: "1800 Martin Luther King Jr"},
+		},
+		{
+			name:   "street name with suffix",
+			meta:   berkeley,
+			text:   "Copy, Shattuck Ave and Thousand Oaks",
+			expect: Address{City: "Berkeley", Streets: []string{"Shattuck", "Thousand Oaks"}},
+		},
+		{
+			name:   "scoped to the agency's city",
+			meta:   oakland,
+			text:   "Copy, 40th and Telegraph, check on the welfare of Channing",
+			expect: Address{City: "Oakland", Streets: []string{"40th", "Telegraph"}},
+		},
+		{
+			name:   "city from the streets",
+			meta:   unknown,
+			text:   "At Bancroft and Channing",
+			expect: Address{City: "Berkeley", Streets: []string{"Bancroft", "Channing"}},
+		},
+		{
+			name:   "first address number wins",
+			meta:   berkeley,
+			text:   "3049 Bancroft, cross of 2400 Channing",
+			expect: Address{City: "Berkeley", PrimaryAddress: "3049 Bancroft", Streets: []string{"Channing"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, gazetteer.Extract(test.meta, test.text))
+		})
+	}
+}
+
+func TestDefaultGazetteer(t *testing.T) {
+	var cities []string
+	for _, city := range gazetteer.cities {
+		cities = append(cities, city.City)
+	}
+	assert.Equal(t, []string{"Albany", "Berkeley", "Emeryville", "Oakland"}, cities)
+}
+
+func TestParseGazetteer(t *testing.T) {
+	g, err := ParseGazetteer(fstest.MapFS{
+		"albany.json": {Data: []byte(`{"city": "Albany", "agencies": ["APD"], "streets": [["Solano"], ["San Pablo", "Pablo"]]}`)},
+		"README.md":   {Data: []byte("not a gazetteer")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Solano", "San Pablo", "Pablo"}, g.Streets("albany"))
+	assert.Equal(t, Address{City: "Albany", PrimaryAddress: "1000 San Pablo"}, g.Extract(Metadata{TalkGroupGroup: "APD"}, "1000 Pablo"))
+
+	_, err = ParseGazetteer(fstest.MapFS{"albany.json": {Data: []byte(`{"city": "Albany", "streets": [[]]}`)}})
+	assert.EqualError(t, err, "albany.json: street 0 has no name")
+
+	assert.Contains(t, transcriptionPrompt(), "Martin Luther King Jr, Martin Luther King Junior")
+}
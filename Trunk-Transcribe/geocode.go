@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// geocoderDataPath optionally points at a centerline file that replaces the embedded default
+var geocoderDataPath string = os.Getenv("GEOCODER_DATA")
+
+//go:embed config/centerlines.csv
+var defaultCenterlines []byte
+
+var geocoder = mustParseGeocoder(defaultCenterlines)
+
+// streets meeting closer than this many meters intersect
+const intersectionTolerance = 75
+
+// suffixes dropped from street names when matching them against transcribed addresses
+var streetSuffixes = []string{"st", "ave", "way", "blvd", "rd", "dr", "ct", "pl", "ln", "ter", "street", "avenue", "boulevard", "road", "drive"}
+
+// LatLon is a WGS84 coordinate
+type LatLon struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// MapURL links to the location on a map
+func (l LatLon) MapURL() string {
+	return fmt.Sprintf("https://www.google.com/maps/search/?api=1&query=%.5f,%.5f", l.Lat, l.Lon)
+}
+
+// anchor is the address number of a street at a point along it
+type anchor struct {
+	number int
+	point  LatLon
+}
+
+// centerline is a street of a city as a line through its anchors, in order
+type centerline struct {
+	city    string
+	name    string
+	anchors []anchor
+}
+
+// Geocoder resolves transcribed addresses and intersections to coordinates from street
+// centerlines, without any network service
+type Geocoder struct {
+	cities  []string
+	streets map[string]map[string]*centerline // city, then street key
+}
+
+func mustParseGeocoder(b []byte) *Geocoder {
+	g, err := ParseGeocoder(bytes.NewReader(b))
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// LoadGeocoder reads the centerline file at path
+func LoadGeocoder(path string) (*Geocoder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	g, err := ParseGeocoder(f)
+	if err != nil {
+		return nil, fmt.Errorf("geocoder data %s: %w", path, err)
+	}
+	return g, nil
+}
+
+// ParseGeocoder parses centerline csv with the columns city, street, number, lat and lon. Each row
+// is an anchor: the address number of the street at that point. A street's anchors are listed in
+// order along it.
+func ParseGeocoder(r io.Reader) (*Geocoder, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 5
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Equal(header, []string{"city", "street", "number", "lat", "lon"}) {
+		return nil, fmt.Errorf("unexpected header %v", header)
+	}
+
+	g := &Geocoder{streets: map[string]map[string]*centerline{}}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		number, err1 := strconv.Atoi(record[2])
+		lat, err2 := strconv.ParseFloat(record[3], 64)
+		lon, err3 := strconv.ParseFloat(record[4], 64)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		city := strings.ToLower(record[0])
+		if _, ok := g.streets[city]; !ok {
+			g.cities = append(g.cities, record[0])
+			g.streets[city] = map[string]*centerline{}
+		}
+		key := streetKey(record[1])
+		street, ok := g.streets[city][key]
+		if !ok {
+			street = &centerline{city: record[0], name: record[1]}
+			g.streets[city][key] = street
+		}
+		street.anchors = append(street.anchors, anchor{number: number, point: LatLon{Lat: lat, Lon: lon}})
+	}
+	return g, nil
+}
+
+// streetKey is the lowercase street name without its suffix, "Durant Ave" becomes "durant"
+func streetKey(name string) string {
+	words := strings.Fields(strings.ToLower(strings.ReplaceAll(name, ".", "")))
+	if len(words) > 1 && slices.Contains(streetSuffixes, words[len(words)-1]) {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
+
+// Geocode locates the address extracted from the call, filling in its city, normalized form and
+// location. The city is the one the address was extracted in, or else is taken from the call's
+// talkgroup group, falling back to its system. If none names a known city the address is looked
+// up in every city, and only used if exactly one has it. The address is returned unchanged if it
+// cannot be located.
+func (g *Geocoder) Geocode(meta Metadata, addr Address) Address {
+	if g == nil {
+		return addr
+	}
+
+	cities := g.citiesFor(meta)
+	if i := slices.IndexFunc(g.cities, func(city string) bool { return strings.EqualFold(city, addr.City) }); i >= 0 {
+		cities = g.cities[i : i+1]
+	}
+	type match struct {
+		city       string
+		normalized string
+		location   LatLon
+	}
+	var matches []match
+	for _, city := range cities {
+		var normalized string
+		var location LatLon
+		var ok bool
+		switch {
+		case addr.PrimaryAddress != "":
+			normalized, location, ok = g.locateAddress(city, addr.PrimaryAddress)
+		case len(addr.Streets) > 1:
+			normalized, location, ok = g.locateIntersection(city, addr.Streets[0], addr.Streets[1])
+		}
+		if ok {
+			matches = append(matches, match{city, normalized + ", " + city, location})
+		}
+	}
+
+	if len(matches) != 1 {
+		if len(matches) > 1 {
+			log.Printf("[geocode] %q is ambiguous without a city", addr.String())
+		}
+		return addr
+	}
+	addr.City = matches[0].city
+	addr.Normalized = matches[0].normalized
+	addr.Location = &matches[0].location
+	return addr
+}
+
+// citiesFor returns the cities the call may be in
+func (g *Geocoder) citiesFor(meta Metadata) []string {
+	for _, name := range []string{meta.TalkGroupGroup, meta.ShortName} {
+		name = strings.ToLower(name)
+		for _, city := range g.cities {
+			if strings.Contains(name, strings.ToLower(city)) {
+				return []string{city}
+			}
+		}
+	}
+	return g.cities
+}
+
+func (g *Geocoder) street(city, name string) (*centerline, bool) {
+	street, ok := g.streets[strings.ToLower(city)][streetKey(name)]
+	return street, ok
+}
+
+// locateAddress interpolates the location of an address such as "2605 Durant" along the street
+func (g *Geocoder) locateAddress(city, address string) (string, LatLon, bool) {
+	numberStr, name, _ := strings.Cut(address, " ")
+	number, err := strconv.Atoi(numberStr)
+	if err != nil {
+		return "", LatLon{}, false
+	}
+	street, ok := g.street(city, name)
+	if !ok {
+		return "", LatLon{}, false
+	}
+
+	for i, a := range street.anchors {
+		if a.number == number {
+			return fmt.Sprintf("%d %s", number, street.name), a.point, true
+		}
+		if i == 0 {
+			continue
+		}
+		b := street.anchors[i-1]
+		if (b.number < number && number < a.number) || (a.number < number && number < b.number) {
+			t := float64(number-b.number) / float64(a.number-b.number)
+			location := LatLon{
+				Lat: b.point.Lat + t*(a.point.Lat-b.point.Lat),
+				Lon: b.point.Lon + t*(a.point.Lon-b.point.Lon),
+			}
+			return fmt.Sprintf("%d %s", number, street.name), location, true
+		}
+	}
+	return "", LatLon{}, false
+}
+
+// locateIntersection finds where two streets meet
+func (g *Geocoder) locateIntersection(city, first, second string) (string, LatLon, bool) {
+	a, ok := g.street(city, first)
+	if !ok {
+		return "", LatLon{}, false
+	}
+	b, ok := g.street(city, second)
+	if !ok || a == b {
+		return "", LatLon{}, false
+	}
+
+	best, bestDist := LatLon{}, math.Inf(1)
+	for i := range a.anchors {
+		for j := range b.anchors {
+			p, dist := closestApproach(a.segment(i), b.segment(j))
+			if dist < bestDist {
+				best, bestDist = p, dist
+			}
+		}
+	}
+	if bestDist > intersectionTolerance {
+		return "", LatLon{}, false
+	}
+	return a.name + " & " + b.name, best, true
+}
+
+// segment returns the i'th segment of the street, from anchor i to the next. The last anchor is a
+// segment of zero length.
+func (c *centerline) segment(i int) [2]LatLon {
+	return [2]LatLon{c.anchors[i].point, c.anchors[min(i+1, len(c.anchors)-1)].point}
+}
+
+// closestApproach returns the midpoint between the closest points of two segments and the distance
+// between them in meters. Coordinates are projected onto a plane, which is accurate at city scale.
+func closestApproach(s, t [2]LatLon) (LatLon, float64) {
+	origin := s[0]
+	scale := math.Cos(origin.Lat * math.Pi / 180)
+	project := func(l LatLon) [2]float64 {
+		return [2]float64{(l.Lon - origin.Lon) * scale * metersPerDegree, (l.Lat - origin.Lat) * metersPerDegree}
+	}
+	unproject := func(p [2]float64) LatLon {
+		return LatLon{Lat: origin.Lat + p[1]/metersPerDegree, Lon: origin.Lon + p[0]/(scale*metersPerDegree)}
+	}
+	p1, p2, q1, q2 := project(s[0]), project(s[1]), project(t[0]), project(t[1])
+
+	// segments that cross meet at their intersection
+	if p, ok := segmentIntersection(p1, p2, q1, q2); ok {
+		return unproject(p), 0
+	}
+
+	// otherwise the closest points include an endpoint of one of the segments
+	best, bestDist := [2]float64{}, math.Inf(1)
+	for _, candidate := range [][3][2]float64{{p1, q1, q2}, {p2, q1, q2}, {q1, p1, p2}, {q2, p1, p2}} {
+		closest := closestOnSegment(candidate[0], candidate[1], candidate[2])
+		if dist := math.Hypot(closest[0]-candidate[0][0], closest[1]-candidate[0][1]); dist < bestDist {
+			best = [2]float64{(closest[0] + candidate[0][0]) / 2, (closest[1] + candidate[0][1]) / 2}
+			bestDist = dist
+		}
+	}
+	return unproject(best), bestDist
+}
+
+// meters in a degree of latitude
+const metersPerDegree = 111_320
+
+func segmentIntersection(p1, p2, q1, q2 [2]float64) ([2]float64, bool) {
+	r := [2]float64{p2[0] - p1[0], p2[1] - p1[1]}
+	s := [2]float64{q2[0] - q1[0], q2[1] - q1[1]}
+	denom := r[0]*s[1] - r[1]*s[0]
+	if denom == 0 {
+		return [2]float64{}, false
+	}
+	qp := [2]float64{q1[0] - p1[0], q1[1] - p1[1]}
+	t := (qp[0]*s[1] - qp[1]*s[0]) / denom
+	u := (qp[0]*r[1] - qp[1]*r[0]) / denom
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return [2]float64{}, false
+	}
+	return [2]float64{p1[0] + t*r[0], p1[1] + t*r[1]}, true
+}
+
+func closestOnSegment(p, a, b [2]float64) [2]float64 {
+	ab := [2]float64{b[0] - a[0], b[1] - a[1]}
+	length := ab[0]*ab[0] + ab[1]*ab[1]
+	if length == 0 {
+		return a
+	}
+	t := ((p[0]-a[0])*ab[0] + (p[1]-a[1])*ab[1]) / length
+	t = max(0, min(1, t))
+	return [2]float64{a[0] + t*ab[0], a[1] + t*ab[1]}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeocode(t *testing.T) {
+	berkeley := Metadata{TalkGroupGroup: "Berkeley", ShortName: "Berkeley"}
+	oakland := Metadata{TalkGroupGroup: "Oakland Police", ShortName: "EBRCSA"}
+	unknown := Metadata{ShortName: "EBRCSA"}
+
+	tests := []struct {
+		name       string
+		meta       Metadata
+		addr       Address
+		normalized string
+		location   LatLon
+	}{
+		{
+			name:       "address",
+			meta:       berkeley,
+			addr:       Address{PrimaryAddress: "2605 Durant"},
+			normalized: "2605 Durant Ave, Berkeley",
+			location:   LatLon{Lat: 37.8676, Lon: -122.25635},
+		},
+		{
+			name:       "intersection",
+			meta:       berkeley,
+			addr:       Address{Streets: []string{"Russell", "California"}},
+			normalized: "Russell St & California St, Berkeley",
+			location:   LatLon{Lat: 37.8566, Lon: -122.2790},
+		},
+		{
+			name:       "city from talkgroup group",
+			meta:       oakland,
+			addr:       Address{PrimaryAddress: "3000 Telegraph"},
+			normalized: "3000 Telegraph Ave, Oakland",
+			location:   LatLon{Lat: 37.8192, Lon: -122.2620},
+		},
+		{
+			name:       "only one city has the address",
+			meta:       unknown,
+			addr:       Address{Streets: []string{"Telegraph", "40th"}},
+			normalized: "Telegraph Ave & 40th St, Oakland",
+			location:   LatLon{Lat: 37.8282, Lon: -122.2620},
+		},
+		{
+			name: "ambiguous city",
+			meta: unknown,
+			addr: Address{PrimaryAddress: "3000 Telegraph"},
+		},
+		{
+			name: "streets that do not meet",
+			meta: berkeley,
+			addr: Address{Streets: []string{"Rose", "Alcatraz"}},
+		},
+		{
+			name: "number off the street",
+			meta: berkeley,
+			addr: Address{PrimaryAddress: "9100 Durant"},
+		},
+		{
+			name: "single street",
+			meta: berkeley,
+			addr: Address{Streets: []string{"Durant"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := geocoder.Geocode(test.meta, test.addr)
+			assert.Equal(t, test.normalized, addr.Normalized)
+			if test.normalized == "" {
+				assert.Nil(t, addr.Location)
+				assert.Equal(t, test.addr, addr)
+				return
+			}
+			require.NotNil(t, addr.Location)
+			assert.InDelta(t, test.location.Lat, addr.Location.Lat, 0.0001)
+			assert.InDelta(t, test.location.Lon, addr.Location.Lon, 0.0001)
+		})
+	}
+}
+
+func TestGeocodedSlackMeta(t *testing.T) {
+	meta := Metadata{TalkGroupGroup: "Berkeley", AudioText: "Can you start for Russell and California please?"}
+	addr := ExtractSlackMeta(meta, BERKELEY, nil).Address
+	assert.Equal(t, "Berkeley", addr.City)
+	assert.Equal(t, "Russell St & California St, Berkeley", addr.Normalized)
+	require.NotNil(t, addr.Location)
+	assert.Equal(t, "https://www.google.com/maps/search/?api=1&query=37.85660,-122.27900", addr.Location.MapURL())
+}
+
+func TestParseGeocoder(t *testing.T) {
+	g, err := ParseGeocoder(strings.NewReader("# comment\ncity,street,number,lat,lon\nAlbany,Solano Ave.,1000,37.8910,-122.2930\nAlbany,Solano Ave.,1200,37.8910,-122.2870\n"))
+	require.NoError(t, err)
+	addr := g.Geocode(Metadata{}, Address{PrimaryAddress: "1100 Solano"})
+	assert.Equal(t, "1100 Solano Ave., Albany", addr.Normalized)
+
+	_, err = ParseGeocoder(strings.NewReader("city,street,lat,lon,number\n"))
+	assert.ErrorContains(t, err, "unexpected header")
+
+	_, err = ParseGeocoder(strings.NewReader("city,street,number,lat,lon\nAlbany,Solano Ave,x,37.8910,-122.2930\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
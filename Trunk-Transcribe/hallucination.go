@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// hallucinations are phrases whisper models are known to produce for silence or static. A
+// transcription made up entirely of them is treated as empty, a segment of them is only suspect
+// when whisper was also unsure of it, as dispatchers do say "Thank you."
+var hallucinations = map[string]bool{
+	"you":                    true,
+	"thank you":              true,
+	"thank you very much":    true,
+	"thanks for watching":    true,
+	"thank you for watching": true,
+	"please subscribe":       true,
+	"bye":                    true,
+	"music":                  true,
+	"silence":                true,
+	"blank_audio":            true,
+}
+
+// reasons a segment is dropped or marked suspect
+const (
+	suspectNoSpeech      = "no_speech"
+	suspectLowConfidence = "low_confidence"
+	suspectRepetition    = "repetition"
+	suspectHallucination = "hallucination"
+)
+
+// whisper only trusts no_speech_prob when the segment's avg_logprob is also below this
+const noSpeechLogProb = -1
+
+// a segment of hallucinated phrases is suspect when its avg_logprob is below or its
+// no_speech_prob above these
+const (
+	hallucinationLogProb      = -0.5
+	hallucinationNoSpeechProb = 0.5
+)
+
+// SegmentFilter drops segments that whisper most likely made up, e.g. from squelch tails. Whisper's
+// confidence measures are only checked when the backend reports them; a zero threshold disables
+// its check.
+type SegmentFilter struct {
+	MaxNoSpeechProb     float64 `json:"max_no_speech_prob,omitempty"`
+	MinAvgLogProb       float64 `json:"min_avg_logprob,omitempty"`
+	MaxCompressionRatio float64 `json:"max_compression_ratio,omitempty"`
+	Mark                bool    `json:"mark,omitempty"` // keep suspect segments, marked with the reason
+}
+
+// Apply filters the transcription's segments, rebuilding its text from the segments that remain
+// and counting the dropped or marked segments by reason
+func (f SegmentFilter) Apply(t Transcription) Transcription {
+	var segments []Segment
+	for _, segment := range t.Segments {
+		segment.Suspect = f.suspect(segment)
+		if segment.Suspect == "" {
+			segments = append(segments, segment)
+			continue
+		}
+
+		if t.Dropped == nil {
+			t.Dropped = make(map[string]int)
+		}
+		t.Dropped[segment.Suspect]++
+		if f.Mark {
+			segments = append(segments, segment)
+		}
+	}
+	filterStats.add(len(t.Segments), t.Dropped)
+
+	if len(t.Dropped) > 0 && !f.Mark {
+		texts := make([]string, len(segments))
+		for i, segment := range segments {
+			texts[i] = strings.TrimSpace(segment.Text)
+		}
+		t.Text = strings.Join(texts, " ")
+	}
+	t.Segments = segments
+	return t
+}
+
+// suspect returns why the segment should not be trusted, if at all
+func (f SegmentFilter) suspect(segment Segment) string {
+	switch {
+	case f.MaxNoSpeechProb > 0 && segment.NoSpeechProb > f.MaxNoSpeechProb && segment.AvgLogProb < noSpeechLogProb:
+		return suspectNoSpeech
+	case f.MinAvgLogProb < 0 && segment.AvgLogProb < f.MinAvgLogProb:
+		return suspectLowConfidence
+	case f.MaxCompressionRatio > 0 && segment.CompressionRation > f.MaxCompressionRatio:
+		return suspectRepetition
+	case isHallucinatedText(segment.Text) && strings.TrimSpace(segment.Text) != "" &&
+		(segment.AvgLogProb < hallucinationLogProb || segment.NoSpeechProb > hallucinationNoSpeechProb):
+		return suspectHallucination
+	case isRepetitive(segment.Text):
+		return suspectRepetition
+	}
+	return ""
+}
+
+// hasSpeech reports whether the transcription contains any trusted text that is not a known
+// hallucination
+func hasSpeech(t Transcription) bool {
+	return !isHallucinatedText(trustedText(t.Text, t.Segments))
+}
+
+// trustedText returns the text of the segments that are not suspect, or text if there are no
+// segments
+func trustedText(text string, segments []Segment) string {
+	if len(segments) == 0 {
+		return text
+	}
+	var texts []string
+	for _, segment := range segments {
+		if segment.Suspect == "" {
+			texts = append(texts, strings.TrimSpace(segment.Text))
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// isHallucinatedText reports whether text is empty or made up entirely of hallucinated phrases,
+// e.g. "Thank you. Thank you. Thank you."
+func isHallucinatedText(text string) bool {
+	for _, sentence := range sentences(text) {
+		if !hallucinations[sentence] {
+			return false
+		}
+	}
+	return true
+}
+
+// isRepetitive reports whether text is the same phrase repeated three or more times, which
+// whisper tends to produce when it loses track of the audio
+func isRepetitive(text string) bool {
+	phrases := sentences(text)
+	if len(phrases) < 3 {
+		return false
+	}
+	for _, phrase := range phrases[1:] {
+		if phrase != phrases[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// sentences splits text into normalized sentences or phrases
+func sentences(text string) (sentences []string) {
+	for _, sentence := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == '.' || r == '!' || r == '?' || r == '\n'
+	}) {
+		sentence = strings.ToLower(strings.Trim(sentence, " []()*♪-"))
+		if sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+	return sentences
+}
+
+// FilterStats counts the segments transcribed and those dropped or marked by reason, for tuning
+// the filter thresholds
+type FilterStats struct {
+	Segments int64            `json:"segments"`
+	Dropped  map[string]int64 `json:"dropped"`
+}
+
+type filterCounter struct {
+	mu    sync.Mutex
+	stats FilterStats
+}
+
+var filterStats = &filterCounter{stats: FilterStats{Dropped: map[string]int64{}}}
+
+func (c *filterCounter) add(segments int, dropped map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Segments += int64(segments)
+	for reason, n := range dropped {
+		c.stats.Dropped[reason] += int64(n)
+	}
+}
+
+// Snapshot returns a copy of the counts
+func (c *filterCounter) Snapshot() FilterStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := FilterStats{Segments: c.stats.Segments, Dropped: make(map[string]int64, len(c.stats.Dropped))}
+	for reason, n := range c.stats.Dropped {
+		snapshot.Dropped[reason] = n
+	}
+	return snapshot
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentFilter(t *testing.T) {
+	filter := SegmentFilter{MaxNoSpeechProb: 0.6, MinAvgLogProb: -1.5, MaxCompressionRatio: 2.4}
+
+	tests := []struct {
+		name     string
+		filter   SegmentFilter
+		segments []Segment
+		text     string
+		dropped  map[string]int
+		speech   bool
+	}{
+		{
+			name: "confident segments are kept",
+			segments: []Segment{
+				{Start: 0, End: 2.8, Text: " Berkeley, 2605 Durant.", AvgLogProb: -0.4, NoSpeechProb: 0.1, CompressionRation: 1.1},
+				{Start: 2.9, End: 4.2, Text: " Copy.", AvgLogProb: -0.8, NoSpeechProb: 0.2, CompressionRation: 0.7},
+			},
+			text:   "Berkeley, 2605 Durant. Copy.",
+			speech: true,
+		},
+		{
+			name: "squelch tail is dropped",
+			segments: []Segment{
+				{Start: 0, End: 2.8, Text: " Berkeley, 2605 Durant.", AvgLogProb: -0.4, NoSpeechProb: 0.1},
+				{Start: 2.9, End: 4.2, Text: " Thank you.", AvgLogProb: -0.6, NoSpeechProb: 0.3},
+			},
+			text:    "Berkeley, 2605 Durant.",
+			dropped: map[string]int{suspectHallucination: 1},
+			speech:  true,
+		},
+		{
+			name: "confident hallucination phrases are kept",
+			segments: []Segment{
+				{Start: 0, End: 2.8, Text: " Engine 2 on scene.", AvgLogProb: -0.3, NoSpeechProb: 0.1},
+				{Start: 2.9, End: 3.6, Text: " Thank you.", AvgLogProb: -0.2, NoSpeechProb: 0.05},
+				{Start: 3.6, End: 4.2, Text: " Bye.", AvgLogProb: -0.4, NoSpeechProb: 0.6},
+			},
+			text:    "Engine 2 on scene. Thank you.",
+			dropped: map[string]int{suspectHallucination: 1},
+			speech:  true,
+		},
+		{
+			name: "confidence thresholds",
+			segments: []Segment{
+				{Start: 0, End: 1, Text: " Engine 2.", AvgLogProb: -1.2, NoSpeechProb: 0.9},
+				{Start: 1, End: 2, Text: " on scene.", AvgLogProb: -1.8, NoSpeechProb: 0.1},
+				{Start: 2, End: 3, Text: " Medic 1 Medic 1 Medic 1 Medic 1", AvgLogProb: -0.3, CompressionRation: 3.1},
+			},
+			dropped: map[string]int{suspectNoSpeech: 1, suspectLowConfidence: 1, suspectRepetition: 1},
+		},
+		{
+			name: "repeated phrase without a compression ratio",
+			segments: []Segment{
+				{Text: "Copy that. Copy that. Copy that. Copy that."},
+			},
+			dropped: map[string]int{suspectRepetition: 1},
+		},
+		{
+			name:   "marked segments are kept but not trusted",
+			filter: SegmentFilter{Mark: true},
+			segments: []Segment{
+				{Start: 0, End: 1, Text: " Thank you.", AvgLogProb: -0.7},
+				{Start: 1, End: 2, Text: " Bye.", NoSpeechProb: 0.8},
+			},
+			text:    "Thank you. Bye.",
+			dropped: map[string]int{suspectHallucination: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := filter
+			if test.filter != (SegmentFilter{}) {
+				f = test.filter
+			}
+
+			var text string
+			for _, segment := range test.segments {
+				text += segment.Text
+			}
+			transcription := f.Apply(Transcription{Text: strings.TrimSpace(text), Segments: test.segments})
+			assert.Equal(t, test.text, transcription.Text)
+			assert.Equal(t, test.dropped, transcription.Dropped)
+			assert.Equal(t, test.speech, hasSpeech(transcription))
+		})
+	}
+}
+
+func TestHallucinationsDoNotMention(t *testing.T) {
+	meta := Metadata{
+		AudioText: "Bike versus auto. Thank you.",
+		Segments: []Segment{
+			{Start: 0, End: 2, Text: "Bike versus auto.", Suspect: suspectLowConfidence},
+			{Start: 2, End: 3, Text: "Thank you.", Suspect: suspectHallucination},
+		},
+	}
+	assert.Empty(t, ExtractSlackMeta(meta, BERKELEY, notifsMap).Mentions)
+
+	meta = Metadata{AudioText: "Thank you. Thank you. Thank you."}
+	assert.Empty(t, ExtractSlackMeta(meta, BERKELEY, notifsMap).Mentions)
+
+	meta = Metadata{AudioText: "Bike versus auto on Shattuck"}
+	assert.NotEmpty(t, ExtractSlackMeta(meta, BERKELEY, notifsMap).Mentions)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// an incident stays open while related calls keep arriving within INCIDENT_WINDOW_MINUTES of each
+// other, for at most incidentMaxAge
+var incidentWindow = time.Duration(getenvInt("INCIDENT_WINDOW_MINUTES", 15)) * time.Minute
+
+const incidentMaxAge = 3 * time.Hour
+
+// correlation scores. A call joins the open incident it scores highest against, if it scores at
+// least incidentThreshold: a talkgroup alone is not enough to relate two calls.
+const (
+	scoreAddress      = 2
+	scoreUnit         = 2
+	scoreTalkgroup    = 1
+	incidentThreshold = 2
+)
+
+// Incident is a group of related calls in a slack channel, threaded under the first call's post
+type Incident struct {
+	ID         int64
+	Channel    SlackChannelID
+	Talkgroups []int64
+	Units      []int64  // units heard on the calls, other than dispatchers
+	Addresses  []string // addresses and intersections mentioned on the calls
+	FileID     string   // slack file posted for the first call
+	ThreadTS   string   // timestamp of the first call's message, once resolved
+	Calls      int
+	Opened     time.Time
+	Updated    time.Time
+}
+
+// Incidents stores the open incidents of each channel and correlates calls with them
+type Incidents struct {
+	mu     sync.Mutex
+	open   map[SlackChannelID][]*Incident
+	nextID int64
+	window time.Duration
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+func NewIncidents(window, maxAge time.Duration) *Incidents {
+	return &Incidents{
+		open:   map[SlackChannelID][]*Incident{},
+		window: window,
+		maxAge: maxAge,
+		now:    time.Now,
+	}
+}
+
+// incidentUnits returns the units on the call, leaving out dispatchers who are on every call
+func incidentUnits(meta Metadata) (units []int64) {
+	for _, src := range meta.SrcList {
+		if src.Src == 0 || strings.Contains(strings.ToLower(src.Tag), "dispatch") || slices.Contains(units, src.Src) {
+			continue
+		}
+		units = append(units, src.Src)
+	}
+	return units
+}
+
+// incidentAddress returns the address or intersection on the call. A lone street is too vague to
+// relate calls.
+func incidentAddress(addr Address) string {
+	if addr.PrimaryAddress == "" && len(addr.Streets) < 2 {
+		return ""
+	}
+	return strings.ToLower(addr.String())
+}
+
+// score rates how likely the call is part of the incident
+func (incident *Incident) score(meta Metadata, addr Address) (score int) {
+	if address := incidentAddress(addr); address != "" && slices.Contains(incident.Addresses, address) {
+		score += scoreAddress
+	}
+	if slices.ContainsFunc(incidentUnits(meta), func(unit int64) bool { return slices.Contains(incident.Units, unit) }) {
+		score += scoreUnit
+	}
+	if slices.Contains(incident.Talkgroups, meta.Talkgroup) {
+		score += scoreTalkgroup
+	}
+	return score
+}
+
+// add records the call's talkgroup, units and address on the incident
+func (incident *Incident) add(meta Metadata, addr Address, now time.Time) {
+	if !slices.Contains(incident.Talkgroups, meta.Talkgroup) {
+		incident.Talkgroups = append(incident.Talkgroups, meta.Talkgroup)
+	}
+	for _, unit := range incidentUnits(meta) {
+		if !slices.Contains(incident.Units, unit) {
+			incident.Units = append(incident.Units, unit)
+		}
+	}
+	if address := incidentAddress(addr); address != "" && !slices.Contains(incident.Addresses, address) {
+		incident.Addresses = append(incident.Addresses, address)
+	}
+	incident.Calls++
+	incident.Updated = now
+}
+
+// Correlate finds the open incident in the channel the call is part of and adds the call to it. It
+// returns false if the call is unrelated to every open incident, or if it is an emergency, which is
+// always posted to the channel. Incidents that have expired are closed.
+func (c *Incidents) Correlate(channel SlackChannelID, meta Metadata, addr Address) (Incident, bool) {
+	if c == nil || meta.IsEmergency() {
+		return Incident{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.expire(now)
+
+	var best *Incident
+	bestScore := incidentThreshold - 1
+	for _, incident := range c.open[channel] {
+		// ties go to the most recently updated incident
+		if score := incident.score(meta, addr); score > bestScore || (score == bestScore && best != nil && incident.Updated.After(best.Updated)) {
+			best, bestScore = incident, score
+		}
+	}
+	if best == nil {
+		return Incident{}, false
+	}
+	best.add(meta, addr, now)
+	return best.snapshot(), true
+}
+
+// Open starts an incident in the channel with the call posted as file
+func (c *Incidents) Open(channel SlackChannelID, meta Metadata, addr Address, fileID string) Incident {
+	if c == nil {
+		return Incident{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.nextID++
+	incident := &Incident{ID: c.nextID, Channel: channel, FileID: fileID, Opened: now}
+	incident.add(meta, addr, now)
+	c.open[channel] = append(c.open[channel], incident)
+	return incident.snapshot()
+}
+
+// SetThread records the timestamp of the incident's first message
+func (c *Incidents) SetThread(channel SlackChannelID, id int64, ts string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, incident := range c.open[channel] {
+		if incident.ID == id {
+			incident.ThreadTS = ts
+		}
+	}
+}
+
+// Len returns the number of open incidents
+func (c *Incidents) Len() (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(c.now())
+	for _, incidents := range c.open {
+		n += len(incidents)
+	}
+	return n
+}
+
+// expire closes the incidents idle for longer than the window or open for longer than the max age
+func (c *Incidents) expire(now time.Time) {
+	for channel, incidents := range c.open {
+		incidents = slices.DeleteFunc(incidents, func(incident *Incident) bool {
+			return now.Sub(incident.Updated) > c.window || now.Sub(incident.Opened) > c.maxAge
+		})
+		if len(incidents) == 0 {
+			delete(c.open, channel)
+		} else {
+			c.open[channel] = incidents
+		}
+	}
+}
+
+// snapshot copies the incident so it can be used outside the lock
+func (incident *Incident) snapshot() Incident {
+	copied := *incident
+	copied.Talkgroups = slices.Clone(incident.Talkgroups)
+	copied.Units = slices.Clone(incident.Units)
+	copied.Addresses = slices.Clone(incident.Addresses)
+	return copied
+}
+
+// threadTS returns the timestamp of the message slack posted the file in, in the channel
+func threadTS(ctx context.Context, client *slack.Client, channel SlackChannelID, fileID string) (string, error) {
+	file, _, _, err := client.GetFileInfoContext(ctx, fileID, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	for _, shares := range []map[string][]slack.ShareFileInfo{file.Shares.Public, file.Shares.Private} {
+		if share := shares[string(channel)]; len(share) > 0 {
+			return share[0].Ts, nil
+		}
+	}
+	return "", errors.New("file " + fileID + " is not shared in " + string(channel))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncidentCorrelation(t *testing.T) {
+	call := func(talkgroup int64, units ...int64) Metadata {
+		meta := Metadata{Talkgroup: talkgroup, SrcList: []Source{{Src: 3113008, Tag: "Dispatch"}}}
+		for _, unit := range units {
+			meta.SrcList = append(meta.SrcList, Source{Src: unit})
+		}
+		return meta
+	}
+	durant := Address{PrimaryAddress: "2605 Durant"}
+	intersection := Address{Streets: []string{"Russell", "California"}}
+
+	tests := []struct {
+		name    string
+		channel SlackChannelID
+		meta    Metadata
+		addr    Address
+		after   time.Duration
+		related bool
+		calls   int
+	}{
+		{
+			name:    "same address",
+			meta:    call(3105),
+			addr:    durant,
+			related: true,
+			calls:   2,
+		},
+		{
+			name:    "same unit on another talkgroup",
+			meta:    call(3405, 3124119),
+			related: true,
+			calls:   2,
+		},
+		{
+			name: "dispatcher and talkgroup only",
+			meta: call(3105),
+		},
+		{
+			name: "lone street",
+			meta: call(3105),
+			addr: Address{Streets: []string{"Durant"}},
+		},
+		{
+			name:  "other address",
+			meta:  call(3105, 3124120),
+			addr:  intersection,
+			after: time.Minute,
+		},
+		{
+			name:    "other channel",
+			channel: OAKLAND,
+			meta:    call(3105, 3124119),
+			addr:    durant,
+		},
+		{
+			name:  "after window",
+			meta:  call(3105, 3124119),
+			addr:  durant,
+			after: 16 * time.Minute,
+		},
+		{
+			name: "emergency",
+			meta: Metadata{Talkgroup: 3105, Emergency: 1, SrcList: []Source{{Src: 3124119}}},
+			addr: durant,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Date(2024, 6, 1, 10, 0, 0, 0, location)
+			incidents := NewIncidents(15*time.Minute, 3*time.Hour)
+			incidents.now = func() time.Time { return now }
+
+			opened := incidents.Open(BERKELEY, call(3105, 3124119), durant, "F01")
+			assert.Equal(t, 1, opened.Calls)
+			assert.Equal(t, []int64{3124119}, opened.Units, "dispatchers are not incident units")
+
+			now = now.Add(test.after)
+			incident, related := incidents.Correlate(cmp.Or(test.channel, BERKELEY), test.meta, test.addr)
+			assert.Equal(t, test.related, related)
+			if related {
+				assert.Equal(t, opened.ID, incident.ID)
+				assert.Equal(t, "F01", incident.FileID)
+				assert.Equal(t, test.calls, incident.Calls)
+			}
+		})
+	}
+
+	t.Run("best match", func(t *testing.T) {
+		incidents := NewIncidents(15*time.Minute, 3*time.Hour)
+		fire := incidents.Open(BERKELEY, call(2105, 2101), durant, "F01")
+		crash := incidents.Open(BERKELEY, call(3105, 3124119), intersection, "F02")
+
+		incident, related := incidents.Correlate(BERKELEY, call(3105, 2101), Address{})
+		require.True(t, related)
+		assert.Equal(t, fire.ID, incident.ID, "a shared unit outweighs a shared talkgroup")
+		assert.ElementsMatch(t, []int64{2105, 3105}, incident.Talkgroups)
+
+		incident, related = incidents.Correlate(BERKELEY, call(3105, 3124119), intersection)
+		require.True(t, related)
+		assert.Equal(t, crash.ID, incident.ID)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		now := time.Date(2024, 6, 1, 10, 0, 0, 0, location)
+		incidents := NewIncidents(15*time.Minute, time.Hour)
+		incidents.now = func() time.Time { return now }
+
+		opened := incidents.Open(BERKELEY, call(3105, 3124119), durant, "F01")
+		for range 5 {
+			now = now.Add(14 * time.Minute)
+			_, related := incidents.Correlate(BERKELEY, call(3105, 3124119), Address{})
+			assert.Equal(t, now.Sub(opened.Opened) <= time.Hour, related, now.Sub(opened.Opened))
+		}
+		assert.Equal(t, 0, incidents.Len(), "incidents close after the max age")
+	})
+
+	t.Run("nil incidents", func(t *testing.T) {
+		var incidents *Incidents
+		_, related := incidents.Correlate(BERKELEY, call(3105, 3124119), durant)
+		assert.False(t, related)
+		incidents.Open(BERKELEY, call(3105, 3124119), durant, "F01")
+	})
+}
+
+func TestIncidentThread(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/files.info", r.URL.Path)
+		r.ParseForm()
+		assert.Equal(t, "F01", r.Form.Get("file"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"ok": true,
+			"file": map[string]any{
+				"id": "F01",
+				"shares": map[string]any{
+					"public": map[string]any{string(BERKELEY): []any{map[string]any{"ts": "1717261200.000100"}}},
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := slack.New("token", slack.OptionAPIURL(server.URL+"/"))
+	ts, err := threadTS(context.Background(), client, BERKELEY, "F01")
+	require.NoError(t, err)
+	assert.Equal(t, "1717261200.000100", ts)
+
+	_, err = threadTS(context.Background(), client, OAKLAND, "F01")
+	assert.ErrorContains(t, err, "not shared")
+}
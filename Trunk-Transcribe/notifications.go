@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// notificationsConfigPath optionally points at a notifications file in the format of the python
+// trunk-transcribe. The bundled config/notifications.json is a template with its destinations
+// redacted, so nothing is loaded unless this is set.
+var notificationsConfigPath string = os.Getenv("NOTIFICATIONS_CONFIG")
+
+// Structures to parse the python trunk-transcribe notifications json of the form:
+//
+//	{
+//	  "^3105@Berkeley$|^3605@Berkeley$": {
+//	    "channels": ["slack://BERKELEY"],
+//	    "append_talkgroup": true,
+//	    "alerts": [
+//	      {
+//	        "channels": ["slack://BERKELEY_SECONDARY", "https://example.com/hook", "psafers://PRIVATE_KEY"],
+//	        "keywords": ["211", "shots fired"]
+//	      }
+//	    ]
+//	  }
+//	}
+//
+// Each key is a regular expression matched against "talkgroup@short_name" of a call, and every
+// matching entry applies. The call is posted to the entry's channels, in addition to its routes in
+// the routing file, and sent to the alert channels when its transcript has one of the keywords.
+// Channels are sink uris, see NewNotifier. Apprise style slack uris with tokens, e.g.
+// slack://TokenA/TokenB/TokenC/#CHANNEL, are posted with the service's own slack clients to the
+// last path segment. Entries without slack channels still transcribe the call for their sinks and
+// alerts. append_talkgroup is accepted for compatibility, the talkgroup is always part of the slack
+// post and the notification title.
+type NotificationsConfig map[string]NotificationsEntry
+
+type NotificationsEntry struct {
+	Channels        []string            `json:"channels,omitempty"`
+	AppendTalkgroup bool                `json:"append_talkgroup,omitempty"`
+	Alerts          []NotificationAlert `json:"alerts,omitempty"`
+}
+
+type NotificationAlert struct {
+	Channels []string `json:"channels"`
+	Keywords []string `json:"keywords"`
+}
+
+// compiledNotification is a validated NotificationsEntry
+type compiledNotification struct {
+	pattern  *regexp.Regexp
+	channels []SlackChannelID
+	sinks    []string
+	alerts   []compiledAlert
+}
+
+type compiledAlert struct {
+	keywords []alertWords
+	sinks    []string
+}
+
+// alertWords are the forms of a text keywords are matched in: its lowercase words split at
+// hyphens, so "code-3" is "code 3", and its words as codeWords joins radio codes, so "2-11" is "211"
+type alertWords struct {
+	split, joined []string
+}
+
+func newAlertWords(text string) alertWords {
+	words := alertWords{joined: codeWords(text)}
+	for _, word := range wordsRegex.FindAllString(strings.ToLower(text), -1) {
+		words.split = append(words.split, strings.FieldsFunc(word, func(r rune) bool { return r == '-' })...)
+	}
+	return words
+}
+
+// in returns whether the keyword appears in text
+func (keyword alertWords) in(text alertWords) bool {
+	return containsWords(text.split, keyword.split) || containsWords(text.joined, keyword.joined)
+}
+
+// Notifications routes calls and fires keyword alerts by talkgroup@short_name
+type Notifications struct {
+	entries []compiledNotification
+}
+
+// notifications is the loaded notifications file, nil if there is none
+var notifications *Notifications
+
+// LoadNotifications reads and validates the notifications file at path
+func LoadNotifications(path string, routing *Routing) (*Notifications, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n, err := ParseNotifications(b, routing)
+	if err != nil {
+		return nil, fmt.Errorf("notifications config %s: %w", path, err)
+	}
+	return n, nil
+}
+
+// ParseNotifications parses and validates notifications json. Slack channel names are resolved
+// against the routing file.
+func ParseNotifications(b []byte, routing *Routing) (*Notifications, error) {
+	var config NotificationsConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	n := &Notifications{}
+	var errs []error
+	for _, key := range keys {
+		entry, err := config[key].compile(key, routing)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", key, err))
+			continue
+		}
+		n.entries = append(n.entries, entry)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (entry NotificationsEntry) compile(key string, routing *Routing) (compiled compiledNotification, err error) {
+	if compiled.pattern, err = regexp.Compile(key); err != nil {
+		return compiled, fmt.Errorf("invalid talkgroup pattern: %w", err)
+	}
+	if len(entry.Channels) == 0 && len(entry.Alerts) == 0 {
+		return compiled, errors.New("entry has no channels or alerts")
+	}
+
+	for _, uri := range entry.Channels {
+		uri = notificationSink(uri)
+		if !isSink(uri) {
+			channel, err := routing.Channel(uri)
+			if err != nil {
+				return compiled, err
+			}
+			compiled.channels = append(compiled.channels, channel)
+			continue
+		}
+		if _, err := newNotifier(nil, routing.names, uri); err != nil {
+			return compiled, err
+		}
+		compiled.sinks = append(compiled.sinks, uri)
+	}
+
+	for i, alert := range entry.Alerts {
+		if len(alert.Channels) == 0 || len(alert.Keywords) == 0 {
+			return compiled, fmt.Errorf("alert %d has no channels or keywords", i)
+		}
+		var compiledAlert compiledAlert
+		for _, keyword := range alert.Keywords {
+			if words := newAlertWords(keyword); len(words.split) > 0 {
+				compiledAlert.keywords = append(compiledAlert.keywords, words)
+			}
+		}
+		for _, uri := range alert.Channels {
+			uri = notificationSink(uri)
+			if _, err := newNotifier(nil, routing.names, uri); err != nil {
+				return compiled, fmt.Errorf("alert %d: %w", i, err)
+			}
+			compiledAlert.sinks = append(compiledAlert.sinks, uri)
+		}
+		compiled.alerts = append(compiled.alerts, compiledAlert)
+	}
+	return compiled, nil
+}
+
+// notificationSink converts an apprise style slack uri with tokens to the channel it posts to
+func notificationSink(uri string) string {
+	rest, ok := strings.CutPrefix(uri, "slack://")
+	if !ok || !strings.Contains(rest, "/") {
+		return uri
+	}
+	segments := strings.Split(strings.Trim(rest, "/"), "/")
+	return "slack://" + strings.TrimPrefix(segments[len(segments)-1], "#")
+}
+
+// talkgroupKey is the call's "talkgroup@short_name", e.g. "3105@Berkeley"
+func talkgroupKey(meta Metadata) string {
+	return strconv.FormatInt(meta.Talkgroup, 10) + "@" + meta.ShortName
+}
+
+// matching returns the entries whose pattern matches the call
+func (n *Notifications) matching(meta Metadata) []compiledNotification {
+	if n == nil {
+		return nil
+	}
+	key := talkgroupKey(meta)
+	var entries []compiledNotification
+	for _, entry := range n.entries {
+		if entry.pattern.MatchString(key) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Channels returns the slack channels of the entries matching the call
+func (n *Notifications) Channels(meta Metadata) (channels []SlackChannelID) {
+	for _, entry := range n.matching(meta) {
+		for _, channel := range entry.channels {
+			if !slices.Contains(channels, channel) {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels
+}
+
+// Sinks returns the sinks other than slack channels of the entries matching the call
+func (n *Notifications) Sinks(meta Metadata) (sinks []string) {
+	for _, entry := range n.matching(meta) {
+		sinks = appendSinks(sinks, entry.sinks...)
+	}
+	return sinks
+}
+
+// HasAlerts returns whether any entry matching the call has alerts
+func (n *Notifications) HasAlerts(meta Metadata) bool {
+	return slices.ContainsFunc(n.matching(meta), func(entry compiledNotification) bool { return len(entry.alerts) > 0 })
+}
+
+// Alerts returns the sinks of the alerts whose keywords are in the call's transcript
+func (n *Notifications) Alerts(meta Metadata) (sinks []string) {
+	words := newAlertWords(callText(meta))
+	for _, entry := range n.matching(meta) {
+		for _, alert := range entry.alerts {
+			if slices.ContainsFunc(alert.keywords, func(keyword alertWords) bool { return keyword.in(words) }) {
+				sinks = appendSinks(sinks, alert.sinks...)
+			}
+		}
+	}
+	return sinks
+}
+
+// containsWords returns whether the sequence of words appears in words
+func containsWords(words, sequence []string) bool {
+	for i := 0; i+len(sequence) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(sequence)], sequence) {
+			return true
+		}
+	}
+	return false
+}
+
+// callSinks returns the sinks the call is routed to by the routing and notifications files
+func callSinks(meta Metadata) []string {
+	return appendSinks(slices.Clone(routing.Sinks(meta)), notifications.Sinks(meta)...)
+}
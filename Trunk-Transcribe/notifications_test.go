@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNotifications(t *testing.T) {
+	b, err := os.ReadFile("config/notifications.json")
+	require.NoError(t, err)
+
+	_, err = ParseNotifications(b, routing)
+	require.Error(t, err, "the bundled template has its destinations redacted")
+	assert.Contains(t, err.Error(), `unknown channel "REDACTED_CHANGE"`)
+
+	config := strings.NewReplacer(
+		`"slack://REDACTED_CHANGE"`, `"slack://xoxb-token/#BERKELEY_SECONDARY"`,
+		`"https://REDACTED_CHANGE"`, `"https://example.com/hook"`,
+		`"psafers://REDACTED_CHANGE"`, `"psafers://pkey"`,
+	).Replace(string(b))
+	n, err := ParseNotifications([]byte(config), routing)
+	require.NoError(t, err)
+
+	dispatch := Metadata{Talkgroup: 3605, ShortName: "Berkeley", AudioText: "Report of a 211 strong arm at Telegraph and Dwight"}
+	assert.Equal(t, []SlackChannelID{BERKELEY_SECONDARY}, n.Channels(dispatch))
+	assert.Nil(t, n.Sinks(dispatch))
+	assert.Equal(t, []string{"slack://BERKELEY_SECONDARY", "https://example.com/hook", "psafers://pkey"}, n.Alerts(dispatch))
+
+	dispatch.AudioText = "Copy, 10-4"
+	assert.Nil(t, n.Alerts(dispatch))
+
+	fire := Metadata{Talkgroup: 2105, ShortName: "Berkeley", AudioText: "Engine 2 responding to a working fire"}
+	assert.Len(t, n.Alerts(fire), 3)
+
+	for _, meta := range []Metadata{{Talkgroup: 31050, ShortName: "Berkeley"}, {Talkgroup: 3105, ShortName: "Oakland"}} {
+		assert.Nil(t, n.Channels(meta), talkgroupKey(meta))
+	}
+
+	_, err = ParseNotifications([]byte(`{"^1@Berkeley$": {"channels": ["C06A28PMXFZ"], "alerts": [{"channels": ["gopher://x"], "keywords": ["fire"]}]}}`), routing)
+	require.EqualError(t, err, `"^1@Berkeley$": alert 0: unsupported sink scheme "gopher"`)
+}
+
+func TestNotificationsKeywords(t *testing.T) {
+	n, err := ParseNotifications([]byte(`{
+		"^3105@Berkeley$": {"alerts": [
+			{"channels": ["https://example.com/code-3"], "keywords": ["code-3"]},
+			{"channels": ["https://example.com/code-33"], "keywords": ["code-33"]},
+			{"channels": ["https://example.com/2-11"], "keywords": ["2-11"]}
+		]}
+	}`), routing)
+	require.NoError(t, err)
+
+	tests := []struct {
+		text   string
+		expect []string
+	}{
+		{text: "Engine 2 respond Code 3", expect: []string{"https://example.com/code-3"}},
+		{text: "Engine 2 respond code-3", expect: []string{"https://example.com/code-3"}},
+		{text: "All units, code 33 on this channel", expect: []string{"https://example.com/code-33"}},
+		{text: "We have a 2 11 at Telegraph and Dwight", expect: []string{"https://example.com/2-11"}},
+		{text: "We have a 2-11 at Telegraph and Dwight", expect: []string{"https://example.com/2-11"}},
+		{text: "We have a 211 at Telegraph and Dwight", expect: []string{"https://example.com/2-11"}},
+		{text: "Engine 2 respond code 4, 11 units on scene"},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			meta := Metadata{Talkgroup: 3105, ShortName: "Berkeley", AudioText: test.text}
+			assert.Equal(t, test.expect, n.Alerts(meta))
+		})
+	}
+}
+
+func TestNotificationsRouting(t *testing.T) {
+	n, err := ParseNotifications([]byte(`{
+		"^3105@Berkeley$": {"channels": ["slack://BERKELEY", "slack://UCPD", "ntfys://ntfy.sh/berkeley"]}
+	}`), routing)
+	require.NoError(t, err)
+	notifications = n
+	defer func() { notifications = nil }()
+
+	meta := Metadata{Talkgroup: 3105, ShortName: "Berkeley", TalkGroupGroup: "Berkeley"}
+	assert.Equal(t, []SlackChannelID{BERKELEY, BERKELEY_SECONDARY, UCPD}, channelResolver(meta))
+	assert.Equal(t, []string{"ntfys://ntfy.sh/berkeley"}, callSinks(meta))
+
+	// the routing table is left as it was
+	assert.Equal(t, []SlackChannelID{BERKELEY, BERKELEY_SECONDARY}, routing.Resolve(meta))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// notifsConfigPath optionally points at a notification rules file that replaces the embedded
+// default. The file is watched for changes and reloaded on SIGHUP.
+var notifsConfigPath string = os.Getenv("NOTIFS_CONFIG")
+
+//go:embed config/notifs.json
+var defaultNotifsConfig []byte
+
+// Structures to parse notification rules json of the form:
+//
+//	{
+//	  "users": {
+//	    "U06H9NA2L4V": {
+//	      "name": "Emilie",
+//	      "notifs": [
+//	        {
+//	          "include": ["structure fire", "1033 Frank"],
+//	          "regex": "(bike|ped)s?.+(vs|versus)(\\.)?.+(auto|car)s?",
+//	          "not_regex": "no (weapon|gun)s?",
+//	          "channels": ["BERKELEY", "UCPD"],
+//	          "talkgroups": [3105],
+//	          "notify": ["ntfys://ntfy.sh/emilie-bike"]
+//	        }
+//	      ]
+//	    }
+//	  }
+//	}
+//
+// Channels are either names declared in the routing file or raw slack channel ids. Matching calls
+// are also sent to the notify sinks, see NewNotifier.
+type NotifsConfig struct {
+	Users map[SlackUserID]NotifsUser `json:"users"`
+}
+
+type NotifsUser struct {
+	Name   string       `json:"name,omitempty"`
+	Notifs []NotifsRule `json:"notifs,omitempty"`
+}
+
+type NotifsRule struct {
+	Include    []string      `json:"include,omitempty"`
+	Regex      string        `json:"regex,omitempty"`
+	NotRegex   string        `json:"not_regex,omitempty"`
+	Channels   []string      `json:"channels,omitempty"`
+	TalkGroups []TalkGroupID `json:"talkgroups,omitempty"`
+	Notify     []string      `json:"notify,omitempty"`
+}
+
+// notifsMap is the rule set parsed from the embedded config/notifs.json
+var notifsMap = mustParseNotifs(defaultNotifsConfig, routing)
+
+// configNotifs holds the rule set loaded from the notifs config file
+var configNotifs atomic.Pointer[map[SlackUserID][]Notifs]
+
+// activeNotifs holds the rule set consulted when posting to slack: the config rules merged with
+// the self-service alerts. Reloads swap it atomically so requests in flight keep the rule set
+// they started with.
+var activeNotifs atomic.Pointer[map[SlackUserID][]Notifs]
+
+func init() {
+	configNotifs.Store(&notifsMap)
+	activeNotifs.Store(&notifsMap)
+}
+
+// publishMu serializes publishNotifs so concurrent reloads cannot store a stale merge
+var publishMu sync.Mutex
+
+// publishNotifs merges the config rules with the self-service alerts and makes the result active
+func publishNotifs() {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	base := *configNotifs.Load()
+	merged := make(map[SlackUserID][]Notifs, len(base))
+	for userID, notifs := range base {
+		merged[userID] = append(merged[userID], notifs...)
+	}
+	for userID, notif := range alerts.Notifs() {
+		merged[userID] = append(merged[userID], notif)
+	}
+	activeNotifs.Store(&merged)
+}
+
+// currentNotifs returns the rule set currently in effect
+func currentNotifs() map[SlackUserID][]Notifs {
+	return *activeNotifs.Load()
+}
+
+func mustParseNotifs(b []byte, routing *Routing) map[SlackUserID][]Notifs {
+	notifs, err := ParseNotifs(b, routing)
+	if err != nil {
+		panic(err)
+	}
+	return notifs
+}
+
+// ParseNotifs parses and compiles notification rules json. Channel names are resolved against the
+// routing file.
+func ParseNotifs(b []byte, routing *Routing) (map[SlackUserID][]Notifs, error) {
+	var config NotifsConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+
+	notifs := make(map[SlackUserID][]Notifs, len(config.Users))
+	var errs []error
+	for userID, user := range config.Users {
+		for i, rule := range user.Notifs {
+			notif, err := rule.Compile(routing)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("user %s (%s) rule %d: %w", userID, user.Name, i, err))
+				continue
+			}
+			notifs[userID] = append(notifs[userID], notif)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return notifs, nil
+}
+
+// Compile validates the rule and compiles its regexes
+func (rule NotifsRule) Compile(routing *Routing) (notif Notifs, err error) {
+	if len(rule.Include) == 0 && rule.Regex == "" {
+		return notif, errors.New("rule has no include keywords or regex")
+	}
+
+	if rule.Regex != "" {
+		if notif.Regex, err = regexp.Compile(rule.Regex); err != nil {
+			return notif, fmt.Errorf("invalid regex %q: %w", rule.Regex, err)
+		}
+	}
+	if rule.NotRegex != "" {
+		if notif.NotRegex, err = regexp.Compile(rule.NotRegex); err != nil {
+			return notif, fmt.Errorf("invalid not_regex %q: %w", rule.NotRegex, err)
+		}
+	}
+
+	for _, name := range rule.Channels {
+		channel, err := routing.Channel(name)
+		if err != nil {
+			return notif, err
+		}
+		notif.Channels = append(notif.Channels, channel)
+	}
+
+	for _, sink := range rule.Notify {
+		if _, err := newNotifier(nil, routing.names, sink); err != nil {
+			return notif, err
+		}
+	}
+
+	notif.Include = rule.Include
+	notif.TalkGroups = rule.TalkGroups
+	notif.Notify = rule.Notify
+	return notif, nil
+}
+
+// reloadNotifs reads the rules file at path and swaps it in. On error the previous rule set stays
+// active.
+func reloadNotifs(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	notifs, err := ParseNotifs(b, routing)
+	if err != nil {
+		return fmt.Errorf("notifs config %s: %w", path, err)
+	}
+	configNotifs.Store(&notifs)
+	publishNotifs()
+	log.Printf("Loaded notification rules for %d users from %s", len(notifs), path)
+	return nil
+}
+
+// watchNotifs reloads the rules file at path whenever its modification time changes or a value is
+// received on hup. It returns when ctx is done.
+func watchNotifs(ctx context.Context, path string, interval time.Duration, hup <-chan os.Signal) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("Received SIGHUP, reloading notification rules")
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+		}
+
+		if err := reloadNotifs(path); err != nil {
+			log.Println("Error reloading notification rules, keeping previous rules: ", err)
+		}
+	}
+}
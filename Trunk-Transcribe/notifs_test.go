@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadNotifs(t *testing.T) {
+	defer func() {
+		configNotifs.Store(&notifsMap)
+		publishNotifs()
+	}()
+
+	path := filepath.Join(t.TempDir(), "notifs.json")
+
+	valid := `{"users": {"U1": {"notifs": [{"include": ["bike"], "channels": ["BERKELEY"]}]}}}`
+	require.NoError(t, os.WriteFile(path, []byte(valid), 0644))
+	require.NoError(t, reloadNotifs(path))
+
+	meta := ExtractSlackMeta(Metadata{AudioText: "bike on the path"}, BERKELEY, currentNotifs())
+	assert.Equal(t, []string{"<@U1>"}, meta.Mentions)
+
+	invalid := `{"users": {"U1": {"notifs": [{"include": ["bike"], "regex": "(bike", "channels": ["BERKELEY"]}]}}}`
+	require.NoError(t, os.WriteFile(path, []byte(invalid), 0644))
+	err := reloadNotifs(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `user U1 () rule 0: invalid regex "(bike"`)
+
+	// previous rule set stays active
+	meta = ExtractSlackMeta(Metadata{AudioText: "bike on the path"}, BERKELEY, currentNotifs())
+	assert.Equal(t, []string{"<@U1>"}, meta.Mentions)
+}
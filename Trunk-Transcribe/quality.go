@@ -0,0 +1,244 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signal quality settings. Calls scoring below QUALITY_THRESHOLD (0-100) have a poor signal:
+// QUALITY_ACTION "label" marks them in slack, "skip" also skips transcribing them.
+var qualityThreshold int = getenvInt("QUALITY_THRESHOLD", 30)
+var qualityAction string = getenv("QUALITY_ACTION", qualityLabel)
+
+const (
+	qualityLabel = "label"
+	qualitySkip  = "skip"
+)
+
+const (
+	// decode errors per second of audio at which a call scores 50
+	qualityHalfErrorRate = 30
+	// a spike is weighted as this many decode errors
+	qualitySpikeWeight = 5
+	// how far back /api/quality looks by default
+	qualityWindow = 7 * 24 * time.Hour
+)
+
+// Count is a trunk-recorder counter, which some versions encode as a string
+type Count int64
+
+func (c *Count) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*c = 0
+		return nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	*c = Count(n)
+	return err
+}
+
+// SignalQuality scores how cleanly the call was decoded, from 0 (unusable) to 100 (no errors),
+// based on the decode errors and spikes trunk-recorder counted per transmission. ok is false if the
+// call has no transmissions to score.
+func (meta Metadata) SignalQuality() (score int, ok bool) {
+	if len(meta.FreqList) == 0 {
+		return 0, false
+	}
+	var errs, seconds float64
+	for _, freq := range meta.FreqList {
+		errs += float64(freq.ErrorCount) + qualitySpikeWeight*float64(freq.SpikeCount)
+		seconds += freq.Len
+	}
+	if seconds <= 0 {
+		seconds = float64(meta.CallLength)
+	}
+	if seconds <= 0 {
+		return 0, false
+	}
+	rate := errs / seconds
+	return int(math.Round(100 / (1 + rate/qualityHalfErrorRate))), true
+}
+
+// PoorSignal returns whether the call scores below QUALITY_THRESHOLD
+func (meta Metadata) PoorSignal() bool {
+	score, ok := meta.SignalQuality()
+	return ok && score < qualityThreshold
+}
+
+// QualityLog aggregates call signal quality per site and hour, to spot antenna or gain problems.
+// Each voice frequency belongs to a single site, so sites are tracked by system and frequency.
+type QualityLog struct {
+	db *sql.DB
+}
+
+// SiteQuality is the signal quality of a site's calls, with an hourly breakdown
+type SiteQuality struct {
+	System    string        `json:"system"`
+	Freq      int64         `json:"freq"`
+	Calls     int64         `json:"calls"`
+	Poor      int64         `json:"poor"`
+	Score     float64       `json:"score"`      // mean call score
+	ErrorRate float64       `json:"error_rate"` // decode errors per second of audio
+	Hours     []HourQuality `json:"hours"`
+}
+
+// HourQuality is the signal quality of a site's calls during an hour
+type HourQuality struct {
+	Hour  time.Time `json:"hour"`
+	Calls int64     `json:"calls"`
+	Poor  int64     `json:"poor"`
+	Score float64   `json:"score"`
+}
+
+// NewQualityLog creates the signal quality table if needed
+func NewQualityLog(ctx context.Context, db *sql.DB) (*QualityLog, error) {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS signal_quality (
+			system TEXT NOT NULL,
+			freq INTEGER NOT NULL,
+			hour INTEGER NOT NULL,
+			calls INTEGER NOT NULL,
+			poor INTEGER NOT NULL,
+			score_sum INTEGER NOT NULL,
+			errors INTEGER NOT NULL,
+			spikes INTEGER NOT NULL,
+			seconds REAL NOT NULL,
+			PRIMARY KEY (system, freq, hour)
+		);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return &QualityLog{db: db}, nil
+}
+
+// Record adds the call to its site's totals for the hour it started. Calls without transmission
+// counts are ignored, as is everything when the log is nil.
+func (q *QualityLog) Record(ctx context.Context, meta Metadata) error {
+	score, ok := meta.SignalQuality()
+	if q == nil || !ok {
+		return nil
+	}
+
+	var errs, spikes int64
+	var seconds float64
+	for _, freq := range meta.FreqList {
+		errs += int64(freq.ErrorCount)
+		spikes += int64(freq.SpikeCount)
+		seconds += freq.Len
+	}
+	start := time.Now()
+	if meta.StartTime > 0 {
+		start = time.Unix(meta.StartTime, 0)
+	}
+	poor := 0
+	if meta.PoorSignal() {
+		poor = 1
+	}
+
+	_, err := q.db.ExecContext(ctx, `
+		INSERT INTO signal_quality (system, freq, hour, calls, poor, score_sum, errors, spikes, seconds)
+		VALUES (?, ?, ?, 1, ?, ?, ?, ?, ?)
+		ON CONFLICT (system, freq, hour) DO UPDATE SET
+			calls = calls + 1,
+			poor = poor + excluded.poor,
+			score_sum = score_sum + excluded.score_sum,
+			errors = errors + excluded.errors,
+			spikes = spikes + excluded.spikes,
+			seconds = seconds + excluded.seconds`,
+		meta.ShortName, meta.Freq, start.Truncate(time.Hour).Unix(), poor, score, errs, spikes, seconds)
+	return err
+}
+
+// Sites returns the signal quality of every site of the system (or all systems) since the given
+// time, worst first
+func (q *QualityLog) Sites(ctx context.Context, system string, since time.Time) ([]SiteQuality, error) {
+	rows, err := q.db.QueryContext(ctx, `
+		SELECT system, freq, hour, calls, poor, score_sum, errors, spikes, seconds FROM signal_quality
+		WHERE hour >= ? AND (? = '' OR system = ? COLLATE NOCASE)
+		ORDER BY system, freq, hour`,
+		since.Truncate(time.Hour).Unix(), system, system)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []SiteQuality
+	var scores, errs, seconds float64
+	finish := func() {
+		site := &sites[len(sites)-1]
+		site.Score = scores / float64(site.Calls)
+		if seconds > 0 {
+			site.ErrorRate = errs / seconds
+		}
+		scores, errs, seconds = 0, 0, 0
+	}
+	for rows.Next() {
+		var system string
+		var freq, hour, calls, poor, score, errCount, spikes int64
+		var secs float64
+		if err := rows.Scan(&system, &freq, &hour, &calls, &poor, &score, &errCount, &spikes, &secs); err != nil {
+			return nil, err
+		}
+		if len(sites) == 0 || sites[len(sites)-1].System != system || sites[len(sites)-1].Freq != freq {
+			if len(sites) > 0 {
+				finish()
+			}
+			sites = append(sites, SiteQuality{System: system, Freq: freq})
+		}
+		site := &sites[len(sites)-1]
+		site.Calls += calls
+		site.Poor += poor
+		site.Hours = append(site.Hours, HourQuality{
+			Hour:  time.Unix(hour, 0).In(location),
+			Calls: calls,
+			Poor:  poor,
+			Score: float64(score) / float64(calls),
+		})
+		scores += float64(score)
+		errs += float64(errCount + qualitySpikeWeight*spikes)
+		seconds += secs
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(sites) > 0 {
+		finish()
+	}
+
+	slices.SortStableFunc(sites, func(a, b SiteQuality) int { return cmp.Compare(a.Score, b.Score) })
+	return sites, nil
+}
+
+// handleQuality serves GET /api/quality, the signal quality per site. Optional parameters are
+// system and since, as RFC 3339 or unix seconds.
+func handleQuality(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since := time.Now().Add(-qualityWindow)
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				http.Error(w, "invalid since: "+v, http.StatusBadRequest)
+				return
+			}
+			since = t
+		}
+
+		sites, err := config.quality.Sites(r.Context(), r.URL.Query().Get("system"), since)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sites)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignalQuality(t *testing.T) {
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+	require.Len(t, meta.FreqList, 2)
+	assert.Equal(t, Count(46), meta.FreqList[1].ErrorCount)
+	assert.Equal(t, Count(3), meta.FreqList[1].SpikeCount)
+	assert.Equal(t, 1.44, meta.FreqList[1].Len)
+
+	tests := []struct {
+		name     string
+		freqList string
+		score    int
+		ok       bool
+		poor     bool
+	}{
+		{
+			name:     "sample call",
+			freqList: `[{"len": 2.88, "error_count": "0", "spike_count": "0"}, {"len": 1.44, "error_count": "46", "spike_count": "3"}]`,
+			score:    68,
+			ok:       true,
+		},
+		{
+			name:     "numeric counts",
+			freqList: `[{"len": 4, "error_count": 0, "spike_count": 0}]`,
+			score:    100,
+			ok:       true,
+		},
+		{
+			name:     "poor signal",
+			freqList: `[{"len": 1.08, "error_count": "100", "spike_count": "7"}, {"len": 1, "error_count": "121", "spike_count": "12"}]`,
+			score:    16,
+			ok:       true,
+			poor:     true,
+		},
+		{
+			name:     "no counts",
+			freqList: `[]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var meta Metadata
+			require.NoError(t, json.Unmarshal([]byte(`{"freqList": `+test.freqList+`}`), &meta))
+
+			score, ok := meta.SignalQuality()
+			assert.Equal(t, test.score, score)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.poor, meta.PoorSignal())
+		})
+	}
+}
+
+func TestQualityLog(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	quality, err := NewQualityLog(ctx, db)
+	require.NoError(t, err)
+
+	start := time.Date(2024, 6, 1, 10, 15, 0, 0, location)
+	call := func(freq int64, offset time.Duration, errors Count) Metadata {
+		return Metadata{
+			ShortName: "Berkeley",
+			Freq:      freq,
+			StartTime: start.Add(offset).Unix(),
+			FreqList:  []Frequency{{Freq: freq, Len: 2, ErrorCount: errors}},
+		}
+	}
+	for _, meta := range []Metadata{
+		call(772393750, 0, 0),
+		call(772393750, 10*time.Minute, 60),
+		call(772393750, time.Hour, 0),
+		call(773843750, 0, 300),
+		{ShortName: "Berkeley", Freq: 773843750, StartTime: start.Unix()}, // no counts
+	} {
+		require.NoError(t, quality.Record(ctx, meta))
+	}
+
+	sites, err := quality.Sites(ctx, "berkeley", start.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, sites, 2)
+
+	// worst site first
+	assert.Equal(t, int64(773843750), sites[0].Freq)
+	assert.Equal(t, int64(1), sites[0].Calls)
+	assert.Equal(t, int64(1), sites[0].Poor)
+	assert.Equal(t, 150.0, sites[0].ErrorRate)
+
+	assert.Equal(t, int64(772393750), sites[1].Freq)
+	assert.Equal(t, int64(3), sites[1].Calls)
+	assert.Equal(t, int64(0), sites[1].Poor)
+	assert.Equal(t, 10.0, sites[1].ErrorRate)
+	require.Len(t, sites[1].Hours, 2)
+	assert.Equal(t, start.Truncate(time.Hour), sites[1].Hours[0].Hour)
+	assert.Equal(t, int64(2), sites[1].Hours[0].Calls)
+	assert.Equal(t, 75.0, sites[1].Hours[0].Score)
+
+	sites, err = quality.Sites(ctx, "Oakland", start.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, sites)
+
+	var nilLog *QualityLog
+	assert.NoError(t, nilLog.Record(ctx, call(772393750, 0, 0)))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	queuePending = "pending"
+	queueClaimed = "claimed"
+)
+
+// stepQueue is the dead letter step of requests the queue gave up on. They are stored with the
+// steps they were queued with, so a replay runs them in full.
+const stepQueue = "queue"
+
+// ErrQueueFull is returned by Enqueue when the queue already holds its maximum depth of pending
+// requests
+var ErrQueueFull = errors.New("transcription queue is full")
+
+// Queue is a durable work queue of TranscriptionRequests backed by sqlite. Items are deleted only
+// once processed, so anything claimed when the process dies is replayed on the next start.
+type Queue struct {
+	db          *sql.DB
+	notify      chan struct{}
+	maxDepth    int
+	maxAttempts int // claims of an item before it is given up on
+	workers     atomic.Int64
+	inFlight    atomic.Int64
+}
+
+// QueueStats describes the load on the queue
+type QueueStats struct {
+	Workers  int64 `json:"workers"`
+	InFlight int64 `json:"in_flight"`
+	Pending  int64 `json:"pending"`
+	Claimed  int64 `json:"claimed"`
+	MaxDepth int   `json:"max_depth"`
+}
+
+// QueueItem is a claimed TranscriptionRequest
+type QueueItem struct {
+	ID       int64
+	Attempts int64
+	Request  *TranscriptionRequest
+}
+
+// NewQueue creates the queue table if needed and returns every claimed item to pending, replaying
+// work left unfinished by a previous run. At most maxDepth requests may be pending at once.
+func NewQueue(ctx context.Context, db *sql.DB, maxDepth int) (*Queue, error) {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS queue (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			status TEXT NOT NULL,
+			priority INTEGER NOT NULL DEFAULT 0,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			request BLOB NOT NULL,
+			created_at INTEGER NOT NULL,
+			claimed_at INTEGER
+		);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	if err := addColumn(ctx, db, "queue", "priority", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	_, err = db.ExecContext(ctx, `
+		DROP INDEX IF EXISTS queue_status;
+		CREATE INDEX IF NOT EXISTS queue_claim ON queue (status, priority DESC, id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.ExecContext(ctx, `UPDATE queue SET status = ?, claimed_at = NULL WHERE status = ?`, queuePending, queueClaimed)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Replaying %d unfinished transcription requests", n)
+	}
+
+	return &Queue{
+		db:          db,
+		notify:      make(chan struct{}, 1),
+		maxDepth:    maxDepth,
+		maxAttempts: queueMaxAttempts,
+	}, nil
+}
+
+// Enqueue persists the request and wakes an idle worker. It returns ErrQueueFull rather than
+// blocking when the queue is at its maximum depth. Emergency calls are always accepted, and are
+// claimed ahead of everything else.
+func (q *Queue) Enqueue(ctx context.Context, req *TranscriptionRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	priority := queuePriority(req)
+
+	// check the depth and insert in one statement so concurrent enqueues cannot overshoot it
+	res, err := q.db.ExecContext(ctx, `
+		INSERT INTO queue (status, priority, request, created_at)
+		SELECT ?, ?, ?, ? WHERE ? > ? OR (SELECT COUNT(*) FROM queue WHERE status = ?) < ?
+	`, queuePending, priority, b, time.Now().Unix(), priority, queuePriorityNormal, queuePending, q.maxDepth)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrQueueFull
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Claim marks the oldest pending item of the highest priority as claimed and returns it. It
+// returns nil if the queue is empty.
+func (q *Queue) Claim(ctx context.Context) (*QueueItem, error) {
+	row := q.db.QueryRowContext(ctx, `
+		UPDATE queue SET status = ?, attempts = attempts + 1, claimed_at = ?
+		WHERE id = (SELECT id FROM queue WHERE status = ? ORDER BY priority DESC, id LIMIT 1)
+		RETURNING id, attempts, request
+	`, queueClaimed, time.Now().Unix(), queuePending)
+
+	var item QueueItem
+	var b []byte
+	err := row.Scan(&item.ID, &item.Attempts, &b)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &item.Request); err != nil {
+		// a request that cannot be decoded will never succeed. Drop it rather than replaying it forever
+		log.Printf("Dropping undecodable queue item %d: %v", item.ID, err)
+		return nil, q.Complete(ctx, item.ID)
+	}
+	return &item, nil
+}
+
+// Complete removes a processed item from the queue
+func (q *Queue) Complete(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, `DELETE FROM queue WHERE id = ?`, id)
+	return err
+}
+
+// Stats returns the number of workers, requests being handled and requests waiting in the queue
+func (q *Queue) Stats(ctx context.Context) (QueueStats, error) {
+	pending, claimed, err := q.Len(ctx)
+	return QueueStats{
+		Workers:  q.workers.Load(),
+		InFlight: q.inFlight.Load(),
+		Pending:  pending,
+		Claimed:  claimed,
+		MaxDepth: q.maxDepth,
+	}, err
+}
+
+// Len returns the number of pending and claimed items
+func (q *Queue) Len(ctx context.Context) (pending, claimed int64, err error) {
+	err = q.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(CASE WHEN status = ? THEN 1 END),
+			COUNT(CASE WHEN status = ? THEN 1 END)
+		FROM queue
+	`, queuePending, queueClaimed).Scan(&pending, &claimed)
+	return pending, claimed, err
+}
+
+// Work starts workers goroutines that claim items and pass them to handle until ctx is done. An
+// item is completed after handle returns, so processing is at-least-once. An item that was already
+// claimed maxAttempts times, so it crashed or hung the service each time, or whose handle panics is
+// completed and passed to exhausted instead. Work returns once every worker has finished its
+// current item.
+func (q *Queue) Work(ctx context.Context, workers int, handle func(req *TranscriptionRequest), exhausted func(req *TranscriptionRequest, err error)) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		q.workers.Add(1)
+		go func() {
+			defer wg.Done()
+			defer q.workers.Add(-1)
+			q.work(ctx, handle, exhausted)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context, handle func(req *TranscriptionRequest), exhausted func(req *TranscriptionRequest, err error)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		item, err := q.Claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Println("Error claiming transcription request: ", err)
+		}
+
+		if item == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.notify:
+			case <-ticker.C:
+			}
+			continue
+		}
+
+		if q.maxAttempts > 0 && item.Attempts > int64(q.maxAttempts) {
+			err := fmt.Errorf("gave up after %d attempts", item.Attempts-1)
+			log.Printf("Dead-lettering queue item %d %s: %v", item.ID, item.Request.Filename, err)
+			exhausted(item.Request, err)
+		} else {
+			log.Printf("Requests in flight: %d", q.inFlight.Add(1))
+			if err := handleItem(item, handle); err != nil {
+				log.Printf("Dead-lettering queue item %d %s: %v", item.ID, item.Request.Filename, err)
+				exhausted(item.Request, err)
+			}
+			q.inFlight.Add(-1)
+		}
+
+		// complete even if shutting down: the item has been handled
+		if err := q.Complete(context.Background(), item.ID); err != nil {
+			log.Printf("Error completing queue item %d: %v", item.ID, err)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// handleItem passes the item to handle, returning a panic in handle as an error
+func handleItem(item *QueueItem, handle func(req *TranscriptionRequest)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic handling %s: %v\n%s", item.Request.Filename, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	handle(item.Request)
+	return nil
+}
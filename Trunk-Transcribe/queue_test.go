@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testQueue(t *testing.T, path string, maxDepth int) *Queue {
+	db, err := OpenDB(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	queue, err := NewQueue(context.Background(), db, maxDepth)
+	require.NoError(t, err)
+	return queue
+}
+
+func TestQueueReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+
+	queue := testQueue(t, path, 2)
+	req := &TranscriptionRequest{
+		Filename:      "2105-1702705979_772093750.1-call_130267.wav",
+		Data:          []byte("RIFF"),
+		Meta:          meta,
+		Transcribe:    true,
+		SlackChannels: []SlackChannelID{BERKELEY},
+	}
+	require.NoError(t, queue.Enqueue(ctx, req))
+	require.NoError(t, queue.Enqueue(ctx, req))
+	assert.ErrorIs(t, queue.Enqueue(ctx, req), ErrQueueFull)
+
+	item, err := queue.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	assert.Equal(t, req, item.Request)
+	assert.Equal(t, int64(1), item.Attempts)
+
+	pending, claimed, err := queue.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pending)
+	assert.Equal(t, int64(1), claimed)
+
+	// simulate a restart with the first item still claimed
+	queue = testQueue(t, path, 2)
+	pending, claimed, err = queue.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pending)
+	assert.Equal(t, int64(0), claimed)
+
+	var handled atomic.Int64
+	workCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		queue.Work(workCtx, 2, func(req *TranscriptionRequest) {
+			handled.Add(1)
+		}, func(req *TranscriptionRequest, err error) {
+			t.Errorf("unexpected dead letter: %v", err)
+		})
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return handled.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	pending, claimed, err = queue.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending+claimed)
+}
+
+func TestTranscribeQueueFull(t *testing.T) {
+	queue := testQueue(t, filepath.Join(t.TempDir(), "test.db"), 0)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	js, _ := writer.CreateFormFile("call_json", "call.json")
+	js.Write([]byte(data))
+	audio, _ := writer.CreateFormFile("call_audio", "call.wav")
+	audio.Write([]byte("RIFF"))
+	writer.Close()
+
+	req, err := http.NewRequest("POST", "/transcribe", body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	rr := httptest.NewRecorder()
+	mux(nil, queue).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+}
+
+func TestQueuePriority(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// a queue created before priorities existed
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE queue (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			request BLOB NOT NULL,
+			created_at INTEGER NOT NULL,
+			claimed_at INTEGER
+		);
+		CREATE INDEX queue_status ON queue (status, id);
+	`)
+	require.NoError(t, err)
+	queue, err := NewQueue(ctx, db, 1)
+	require.NoError(t, err)
+
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+	routine := &TranscriptionRequest{Filename: "routine.wav", Meta: meta}
+	meta.Emergency = 1
+	emergency := &TranscriptionRequest{Filename: "emergency.wav", Meta: meta}
+
+	require.NoError(t, queue.Enqueue(ctx, routine))
+	assert.ErrorIs(t, queue.Enqueue(ctx, routine), ErrQueueFull)
+	require.NoError(t, queue.Enqueue(ctx, emergency), "emergencies are accepted when the queue is full")
+
+	for _, expect := range []string{"emergency.wav", "routine.wav"} {
+		item, err := queue.Claim(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, item)
+		assert.Equal(t, expect, item.Request.Filename)
+	}
+}
+
+func TestQueueGivesUp(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	queue := testQueue(t, path, 10)
+	require.NoError(t, queue.Enqueue(ctx, &TranscriptionRequest{Filename: "hangs.wav"}))
+	require.NoError(t, queue.Enqueue(ctx, &TranscriptionRequest{Filename: "panics.wav"}))
+
+	// the first item is claimed and the service dies handling it, on every restart
+	for range 2 {
+		item, err := queue.Claim(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "hangs.wav", item.Request.Filename)
+		queue = testQueue(t, path, 10)
+	}
+	queue.maxAttempts = 2
+
+	var mu sync.Mutex
+	var handled []string
+	exhausted := map[string]string{}
+	workCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		queue.Work(workCtx, 1, func(req *TranscriptionRequest) {
+			mu.Lock()
+			handled = append(handled, req.Filename)
+			mu.Unlock()
+			panic("bad call")
+		}, func(req *TranscriptionRequest, err error) {
+			mu.Lock()
+			exhausted[req.Filename] = err.Error()
+			mu.Unlock()
+		})
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(exhausted) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{"panics.wav"}, handled)
+	assert.Equal(t, map[string]string{"hangs.wav": "gave up after 2 attempts", "panics.wav": "panic: bad call"}, exhausted)
+	pending, claimed, err := queue.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending+claimed)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// the steps of handling a TranscriptionRequest that are retried and dead-lettered independently
+const (
+	stepTranscribe = "transcribe"
+	stepR2         = "r2"
+	stepSlack      = "slack"
+	stepRdio       = "rdio"
+	stepNotify     = "notify"
+)
+
+// RetryPolicy retries a step with exponential backoff and jitter
+type RetryPolicy struct {
+	Attempts int           // total attempts including the first
+	Initial  time.Duration // delay before the second attempt
+	Max      time.Duration // cap on the delay between attempts
+}
+
+var retryPolicies = map[string]RetryPolicy{
+	stepTranscribe: {Attempts: 2, Initial: 5 * time.Second, Max: 15 * time.Second}, // each attempt already falls back through the transcriber chain
+	stepR2:         {Attempts: 5, Initial: time.Second, Max: 30 * time.Second},
+	stepSlack:      {Attempts: 5, Initial: 2 * time.Second, Max: time.Minute},
+	stepRdio:       {Attempts: 5, Initial: 2 * time.Second, Max: time.Minute},
+	stepNotify:     {Attempts: 5, Initial: 2 * time.Second, Max: time.Minute},
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// HTTPStatusError is returned for unsuccessful responses from upstream http apis
+type HTTPStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("response failed with status code: %d and\nbody: %s", e.StatusCode, e.Body)
+}
+
+// newHTTPStatusError builds an HTTPStatusError from the response. Client errors other than 429
+// are permanent.
+func newHTTPStatusError(res *http.Response, body []byte) error {
+	err := &HTTPStatusError{StatusCode: res.StatusCode, Body: string(body)}
+	if seconds, convErr := strconv.Atoi(res.Header.Get("Retry-After")); convErr == nil {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// retry calls fn until it succeeds, returns a permanent error, ctx is done or the step's policy
+// runs out of attempts. Delays honor any Retry-After the upstream asked for.
+func retry(ctx context.Context, step string, fn func(ctx context.Context) error) error {
+	policy, ok := retryPolicies[step]
+	if !ok || policy.Attempts < 1 {
+		policy = RetryPolicy{Attempts: 1}
+	}
+
+	var err error
+	delay := policy.Initial
+	for attempt := 1; ; attempt++ {
+		if err = fn(ctx); err == nil || isPermanent(err) || attempt >= policy.Attempts {
+			return err
+		}
+
+		wait := jitter(delay)
+		if after := retryAfter(err); after > wait {
+			wait = after
+		}
+		log.Printf("[retry] %s attempt %d/%d failed, retrying in %v: %v", step, attempt, policy.Attempts, wait, err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+
+		delay = min(delay*2, policy.Max)
+	}
+}
+
+// jitter returns a random duration in [d/2, d)
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + rand.N(d/2)
+}
+
+func isPermanent(err error) bool {
+	var permanent permanentError
+	var slackErr slack.SlackErrorResponse
+	return errors.As(err, &permanent) || errors.As(err, &slackErr)
+}
+
+// retryAfter returns how long the upstream asked us to wait, if at all
+func retryAfter(err error) time.Duration {
+	var rateLimited *slack.RateLimitedError
+	var statusErr *HTTPStatusError
+	switch {
+	case errors.As(err, &rateLimited):
+		return rateLimited.RetryAfter
+	case errors.As(err, &statusErr):
+		return statusErr.RetryAfter
+	}
+	return 0
+}
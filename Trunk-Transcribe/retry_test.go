@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry(t *testing.T) {
+	retryPolicies["test"] = RetryPolicy{Attempts: 3, Initial: time.Millisecond, Max: 2 * time.Millisecond}
+	defer delete(retryPolicies, "test")
+
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		wantErr  bool
+	}{
+		{
+			name:     "success",
+			errs:     []error{nil},
+			attempts: 1,
+		},
+		{
+			name:     "transient",
+			errs:     []error{errors.New("502"), slack.StatusCodeError{Code: 503}, nil},
+			attempts: 3,
+		},
+		{
+			name:     "exhausted",
+			errs:     []error{errors.New("502"), errors.New("502"), errors.New("502")},
+			attempts: 3,
+			wantErr:  true,
+		},
+		{
+			name:     "slack api error is permanent",
+			errs:     []error{slack.SlackErrorResponse{Err: "channel_not_found"}},
+			attempts: 1,
+			wantErr:  true,
+		},
+		{
+			name:     "client error is permanent",
+			errs:     []error{permanentError{&HTTPStatusError{StatusCode: 400}}},
+			attempts: 1,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := retry(context.Background(), "test", func(ctx context.Context) error {
+				err := test.errs[attempts]
+				attempts++
+				return err
+			})
+			assert.Equal(t, test.attempts, attempts)
+			assert.Equal(t, test.wantErr, err != nil)
+		})
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	retryPolicies["test"] = RetryPolicy{Attempts: 2, Initial: time.Millisecond, Max: time.Millisecond}
+	defer delete(retryPolicies, "test")
+
+	start := time.Now()
+	attempts := 0
+	err := retry(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return &slack.RateLimitedError{RetryAfter: 50 * time.Millisecond}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	adminToken = "admin"
+	defer func() { adminToken = "" }()
+
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	queue, err := NewQueue(ctx, db, 10)
+	require.NoError(t, err)
+	deadLetters, err := NewDeadLetters(ctx, db)
+	require.NoError(t, err)
+	config := &Config{deadLetters: deadLetters}
+
+	req := &TranscriptionRequest{
+		Filename:      "call.wav",
+		Data:          []byte("RIFF"),
+		Transcribe:    true,
+		SlackChannels: []SlackChannelID{UCPD},
+	}
+	deadLetter(ctx, config, req, stepSlack, errors.New("slack server error: 503"))
+
+	mux := mux(config, queue)
+
+	r, _ := http.NewRequest("GET", "/admin/dead-letters", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	r.Header.Set("Authorization", "Bearer admin")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var letters []DeadLetter
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &letters))
+	require.Len(t, letters, 1)
+	assert.Equal(t, stepSlack, letters[0].Step)
+	assert.Equal(t, []string{stepSlack}, letters[0].Request.Steps)
+	assert.Nil(t, letters[0].Request.Data)
+
+	r, _ = http.NewRequest("POST", fmt.Sprintf("/admin/dead-letters/%d/replay", letters[0].ID), nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	item, err := queue.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	assert.Equal(t, []byte("RIFF"), item.Request.Data)
+	assert.True(t, item.Request.Runs(stepSlack))
+	assert.False(t, item.Request.Runs(stepR2))
+
+	letters, err = deadLetters.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestTranscribeReplayReplies(t *testing.T) {
+	ctx := context.Background()
+	retryPolicies[stepTranscribe] = RetryPolicy{Attempts: 1}
+	defer func() {
+		retryPolicies[stepTranscribe] = RetryPolicy{Attempts: 2, Initial: 5 * time.Second, Max: 15 * time.Second}
+	}()
+
+	var uploads int
+	var replies []url.Values
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/files.getUploadURLExternal":
+			uploads++
+			fmt.Fprintf(w, `{"ok": true, "upload_url": "%s/upload", "file_id": "F1"}`, "http://"+r.Host)
+		case "/api/files.completeUploadExternal":
+			w.Write([]byte(`{"ok": true, "files": [{"id": "F1", "title": "call.wav"}]}`))
+		case "/api/files.info":
+			w.Write([]byte(`{"ok": true, "file": {"id": "F1", "shares": {"public": {"C06A28PMXFZ": [{"ts": "1702617247.000100"}]}}}}`))
+		case "/api/chat.postMessage":
+			r.ParseForm()
+			replies = append(replies, r.PostForm)
+			w.Write([]byte(`{"ok": true, "channel": "C06A28PMXFZ", "ts": "1702617250.000200"}`))
+		default:
+			w.Write([]byte(`OK`))
+		}
+	}))
+	defer slackServer.Close()
+	client := slack.New("token", slack.OptionAPIURL(slackServer.URL+"/api/"))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	transcribers := func(endpoint string) *Transcribers {
+		transcribers, err := NewTranscribers(TranscribersConfig{Default: []string{"cloudflare"}}, &CloudflareWhisper{URL: endpoint, Token: "token"})
+		require.NoError(t, err)
+		return transcribers
+	}
+
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	deadLetters, err := NewDeadLetters(ctx, db)
+	require.NoError(t, err)
+	store, err := NewLocalStore(t.TempDir(), "http://localhost/audio")
+	require.NoError(t, err)
+	config := &Config{
+		store:                store,
+		slackClient:          client,
+		slackClientSecondary: client,
+		deadLetters:          deadLetters,
+		transcribers:         transcribers(failing.URL),
+		incidents:            NewIncidents(incidentWindow, incidentMaxAge),
+	}
+
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+	req := &TranscriptionRequest{
+		Filename:      "call.wav",
+		Data:          []byte("RIFF"),
+		Meta:          meta,
+		Transcribe:    true,
+		SlackChannels: []SlackChannelID{BERKELEY},
+		Sinks:         []string{"https://example.com/hook"},
+	}
+	require.NoError(t, transcribeAndUpload(ctx, config, req))
+	assert.Equal(t, 1, uploads)
+
+	letters, err := deadLetters.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, stepTranscribe, letters[0].Step)
+	assert.Equal(t, map[SlackChannelID]string{BERKELEY: "F1"}, letters[0].Request.Posted)
+	assert.Nil(t, letters[0].Request.Sinks, "the sinks were already notified")
+
+	// the replay replies to the original post with the transcript instead of posting again
+	letter, err := deadLetters.Take(ctx, letters[0].ID)
+	require.NoError(t, err)
+	config.transcribers = transcribers(cloudflareStandIn(t, CloudflareWhisperOutput{Success: true, Result: TranscriptionInfo{Text: "Engine 2 on scene"}}).URL)
+	require.NoError(t, transcribeAndUpload(ctx, config, letter.Request))
+	assert.Equal(t, 1, uploads)
+	require.Len(t, replies, 1)
+	assert.Equal(t, "1702617247.000100", replies[0].Get("thread_ts"))
+	assert.Contains(t, replies[0].Get("text"), "Engine 2 on scene")
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// routingConfigPath optionally points at a routing file that replaces the embedded default
+var routingConfigPath string = os.Getenv("ROUTING_CONFIG")
+
+//go:embed config/routing.json
+var defaultRoutingConfig []byte
+
+var slackChannelIDRegex = regexp.MustCompile("^[CG][A-Z0-9]+$")
+
+// Structures to parse routing json of the form:
+//
+//	{
+//	  "channels": {
+//	    "BERKELEY": "C06A28PMXFZ",
+//	    "BERKELEY_SECONDARY": "C09EZKSSDJL"
+//	  },
+//	  "routes": [
+//	    {
+//	      "talkgroups": [2100, 3105],
+//	      "ranges": [{"from": 2105, "to": 2112}],
+//	      "channels": ["BERKELEY", "BERKELEY_SECONDARY"]
+//	    },
+//	    {
+//	      "talkgroup_group": "oakland",
+//	      "talkgroup_tag": "fire dispatch",
+//	      "channels": ["OAKLAND", "C09CYEX1D60", "ntfys://ntfy.sh/oakland-fire"]
+//	    }
+//	  ]
+//	}
+//
+// Routes are evaluated in order and the first matching route wins. Talkgroup ids and ranges are
+// checked before any pattern route regardless of order. talkgroup_group and talkgroup_tag are
+// case-insensitive regular expressions that must match the whole value. Channels are either names
+// declared in the channels map, raw slack channel ids, or either prefixed with slack://. Other uris
+// are notification sinks, see NewNotifier, sent each call alongside the slack channels. A route may
+// have only sinks.
+type RoutingConfig struct {
+	Channels map[string]SlackChannelID `json:"channels,omitempty"`
+	Routes   []Route                   `json:"routes,omitempty"`
+}
+
+type TalkgroupRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+type Route struct {
+	Talkgroups     []int64          `json:"talkgroups,omitempty"`
+	Ranges         []TalkgroupRange `json:"ranges,omitempty"`
+	TalkgroupGroup string           `json:"talkgroup_group,omitempty"`
+	TalkgroupTag   string           `json:"talkgroup_tag,omitempty"`
+	Channels       []string         `json:"channels,omitempty"`
+}
+
+// compiledRoute is a validated Route with its patterns compiled and channel names resolved
+type compiledRoute struct {
+	ranges   []TalkgroupRange
+	group    *regexp.Regexp
+	tag      *regexp.Regexp
+	channels []SlackChannelID
+	sinks    []string
+}
+
+// Routing resolves the slack channels a call is posted to and the sinks it is sent to
+type Routing struct {
+	names      map[string]SlackChannelID
+	talkgroups map[int64]compiledRoute
+	ranges     []compiledRoute
+	patterns   []compiledRoute
+}
+
+// routing is the active routing table used by channelResolver
+var routing = mustParseRouting(defaultRoutingConfig)
+
+func mustParseRouting(b []byte) *Routing {
+	r, err := ParseRouting(b)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// LoadRouting reads and validates the routing file at path
+func LoadRouting(path string) (*Routing, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ParseRouting(b)
+	if err != nil {
+		return nil, fmt.Errorf("routing config %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// ParseRouting parses and validates routing json. Every route must match on something, resolve to
+// at least one slack channel or sink and compile. A talkgroup may only be claimed by one id route.
+func ParseRouting(b []byte) (*Routing, error) {
+	var config RoutingConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+
+	routing := &Routing{
+		names:      config.Channels,
+		talkgroups: make(map[int64]compiledRoute),
+	}
+	var errs []error
+	for i, route := range config.Routes {
+		compiled, err := route.compile(config.Channels)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %d: %w", i, err))
+			continue
+		}
+
+		for _, tg := range route.Talkgroups {
+			if _, ok := routing.talkgroups[tg]; ok {
+				errs = append(errs, fmt.Errorf("route %d: talkgroup %d is routed more than once", i, tg))
+				continue
+			}
+			routing.talkgroups[tg] = compiled
+		}
+		if len(compiled.ranges) > 0 {
+			routing.ranges = append(routing.ranges, compiled)
+		}
+		if compiled.group != nil || compiled.tag != nil {
+			routing.patterns = append(routing.patterns, compiled)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return routing, nil
+}
+
+func (route Route) compile(names map[string]SlackChannelID) (compiled compiledRoute, err error) {
+	hasIDs := len(route.Talkgroups) > 0 || len(route.Ranges) > 0
+	hasPatterns := route.TalkgroupGroup != "" || route.TalkgroupTag != ""
+	switch {
+	case !hasIDs && !hasPatterns:
+		return compiled, errors.New("route has no talkgroups, ranges or patterns")
+	case hasIDs && hasPatterns:
+		return compiled, errors.New("route mixes talkgroup ids with patterns")
+	}
+
+	for _, r := range route.Ranges {
+		if r.From > r.To {
+			return compiled, fmt.Errorf("invalid talkgroup range %d-%d", r.From, r.To)
+		}
+	}
+	compiled.ranges = route.Ranges
+
+	if compiled.group, err = compilePattern(route.TalkgroupGroup); err != nil {
+		return compiled, fmt.Errorf("talkgroup_group: %w", err)
+	}
+	if compiled.tag, err = compilePattern(route.TalkgroupTag); err != nil {
+		return compiled, fmt.Errorf("talkgroup_tag: %w", err)
+	}
+
+	for _, name := range route.Channels {
+		if isSink(name) {
+			if _, err := newNotifier(nil, names, name); err != nil {
+				return compiled, err
+			}
+			compiled.sinks = append(compiled.sinks, name)
+			continue
+		}
+		channel, err := resolveChannelName(names, name)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.channels = append(compiled.channels, channel)
+	}
+	if len(compiled.channels) == 0 && len(compiled.sinks) == 0 {
+		return compiled, errors.New("route has no channels")
+	}
+	return compiled, nil
+}
+
+// compilePattern compiles a case-insensitive regex anchored to the whole value
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)^(?:" + pattern + ")$")
+}
+
+func resolveChannelName(names map[string]SlackChannelID, name string) (SlackChannelID, error) {
+	name = strings.TrimPrefix(name, "slack://")
+	if channel, ok := names[name]; ok {
+		return channel, nil
+	}
+	if slackChannelIDRegex.MatchString(name) {
+		return SlackChannelID(name), nil
+	}
+	return "", fmt.Errorf("unknown channel %q", name)
+}
+
+// Channel resolves a channel name declared in the routing file, or a raw slack channel id
+func (r *Routing) Channel(name string) (SlackChannelID, error) {
+	return resolveChannelName(r.names, name)
+}
+
+// Resolve returns the channels for the call described by meta. Exact talkgroup ids take precedence
+// over ranges which take precedence over talkgroup_group/talkgroup_tag patterns.
+func (r *Routing) Resolve(meta Metadata) []SlackChannelID {
+	route, ok := r.route(meta)
+	if !ok {
+		log.Printf("Could not resolve channel for talkgroup: %s, %s, %d", meta.TalkGroupGroup, meta.TalkgroupTag, meta.Talkgroup)
+		return nil
+	}
+	return route.channels
+}
+
+// Sinks returns the notification sinks of the route the call described by meta matches
+func (r *Routing) Sinks(meta Metadata) []string {
+	route, _ := r.route(meta)
+	return route.sinks
+}
+
+// route returns the first route matching the call, in the precedence order of Resolve
+func (r *Routing) route(meta Metadata) (compiledRoute, bool) {
+	if route, ok := r.talkgroups[meta.Talkgroup]; ok {
+		return route, true
+	}
+
+	for _, route := range r.ranges {
+		for _, rng := range route.ranges {
+			if meta.Talkgroup >= rng.From && meta.Talkgroup <= rng.To {
+				return route, true
+			}
+		}
+	}
+
+	group := strings.TrimSpace(meta.TalkGroupGroup)
+	tag := strings.TrimSpace(meta.TalkgroupTag)
+	for _, route := range r.patterns {
+		if route.group != nil && !route.group.MatchString(group) {
+			continue
+		}
+		if route.tag != nil && !route.tag.MatchString(tag) {
+			continue
+		}
+		return route, true
+	}
+	return compiledRoute{}, false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultRouting(t *testing.T) {
+	tests := []struct {
+		name   string
+		meta   Metadata
+		expect []SlackChannelID
+	}{
+		{
+			name:   "talkgroup id",
+			meta:   Metadata{Talkgroup: 3105, TalkGroupGroup: "Berkeley"},
+			expect: []SlackChannelID{BERKELEY, BERKELEY_SECONDARY},
+		},
+		{
+			name:   "talkgroup range",
+			meta:   Metadata{Talkgroup: 2408},
+			expect: []SlackChannelID{OAKLAND_FIRE},
+		},
+		{
+			name:   "hospital",
+			meta:   Metadata{Talkgroup: HIGHLAND_HOSPITAL_TALKGROUP},
+			expect: []SlackChannelID{HOSPITALS_TRAUMA},
+		},
+		{
+			name:   "group",
+			meta:   Metadata{Talkgroup: 1, TalkGroupGroup: "AMR (CCC)"},
+			expect: []SlackChannelID{AMR_CCC},
+		},
+		{
+			name:   "group and tag",
+			meta:   Metadata{Talkgroup: 1, TalkGroupGroup: "Oakland", TalkgroupTag: "Fire Dispatch"},
+			expect: []SlackChannelID{OAKLAND, OAKLAND_FIRE_SECONDARY},
+		},
+		{
+			name:   "group fallthrough",
+			meta:   Metadata{Talkgroup: 1, TalkGroupGroup: "Oakland", TalkgroupTag: "OPD 1"},
+			expect: []SlackChannelID{OAKLAND, OAKLAND_SECONDARY},
+		},
+		{
+			name:   "unresolved",
+			meta:   Metadata{Talkgroup: 1, TalkGroupGroup: "Berkeley Hills"},
+			expect: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, channelResolver(test.meta))
+		})
+	}
+}
+
+func TestParseRoutingErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		expect string
+	}{
+		{
+			name:   "empty route",
+			config: `{"routes": [{"channels": ["C06A28PMXFZ"]}]}`,
+			expect: "route 0: route has no talkgroups, ranges or patterns",
+		},
+		{
+			name:   "unknown channel",
+			config: `{"routes": [{"talkgroups": [1], "channels": ["NOWHERE"]}]}`,
+			expect: `route 0: unknown channel "NOWHERE"`,
+		},
+		{
+			name:   "bad range",
+			config: `{"routes": [{"ranges": [{"from": 10, "to": 1}], "channels": ["C06A28PMXFZ"]}]}`,
+			expect: "route 0: invalid talkgroup range 10-1",
+		},
+		{
+			name:   "bad pattern",
+			config: `{"routes": [{"talkgroup_group": "amr (ccc", "channels": ["C06A28PMXFZ"]}]}`,
+			expect: "route 0: talkgroup_group",
+		},
+		{
+			name:   "unsupported sink",
+			config: `{"routes": [{"talkgroups": [1], "channels": ["C06A28PMXFZ", "gopher://example.com"]}]}`,
+			expect: `route 0: unsupported sink scheme "gopher"`,
+		},
+		{
+			name:   "no channels",
+			config: `{"routes": [{"talkgroups": [1], "channels": []}]}`,
+			expect: "route 0: route has no channels",
+		},
+		{
+			name:   "duplicate talkgroup",
+			config: `{"routes": [{"talkgroups": [1], "channels": ["C06A28PMXFZ"]}, {"talkgroups": [1], "channels": ["C06A28PMXFZ"]}]}`,
+			expect: "route 1: talkgroup 1 is routed more than once",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseRouting([]byte(test.config))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expect)
+		})
+	}
+}
+
+func TestRoutingSinks(t *testing.T) {
+	r, err := ParseRouting([]byte(`{
+		"channels": {"BERKELEY": "C06A28PMXFZ"},
+		"routes": [
+			{"talkgroups": [3105], "channels": ["slack://BERKELEY", "https://example.com/hook"]},
+			{"talkgroups": [3605], "channels": ["ntfys://ntfy.sh/berkeley"]},
+			{"talkgroup_group": "oakland", "channels": ["C070R7LGVDY"]}
+		]
+	}`))
+	require.NoError(t, err)
+
+	meta := Metadata{Talkgroup: 3105}
+	assert.Equal(t, []SlackChannelID{BERKELEY}, r.Resolve(meta))
+	assert.Equal(t, []string{"https://example.com/hook"}, r.Sinks(meta))
+
+	meta = Metadata{Talkgroup: 3605}
+	assert.Empty(t, r.Resolve(meta))
+	assert.Equal(t, []string{"ntfys://ntfy.sh/berkeley"}, r.Sinks(meta))
+
+	meta = Metadata{Talkgroup: 1, TalkGroupGroup: "Oakland"}
+	assert.Equal(t, []SlackChannelID{OAKLAND}, r.Resolve(meta))
+	assert.Nil(t, r.Sinks(meta))
+	assert.Nil(t, r.Sinks(Metadata{Talkgroup: 1}))
+}
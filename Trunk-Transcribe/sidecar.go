@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Sidecar is stored as json next to each call's audio so the transcript outlives Slack and the
+// archive can be rebuilt from the bucket
+type Sidecar struct {
+	Key      string          `json:"key"` // the audio's key
+	Metadata Metadata        `json:"metadata"`
+	Address  Address         `json:"address,omitempty"`
+	Details  IncidentDetails `json:"details,omitempty"`
+	Mentions []string        `json:"mentions,omitempty"`
+}
+
+// sidecarKey returns the key of the sidecar for the audio at key, e.g. Berkeley/2105/call.json
+// for Berkeley/2105/call.wav
+func sidecarKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".json"
+}
+
+// newSidecar builds the sidecar for the call, with the address and the mentions matched in any of
+// the channels it is posted to
+func newSidecar(key string, meta Metadata, channelIDs []SlackChannelID) Sidecar {
+	sidecar := Sidecar{Key: key, Metadata: meta}
+	notifs := currentNotifs()
+	for _, channelID := range channelIDs {
+		slackMeta := ExtractSlackMeta(meta, channelID, notifs)
+		sidecar.Address = slackMeta.Address
+		sidecar.Details = slackMeta.Details
+		for _, mention := range slackMeta.Mentions {
+			if !slices.Contains(sidecar.Mentions, mention) {
+				sidecar.Mentions = append(sidecar.Mentions, mention)
+			}
+		}
+	}
+	return sidecar
+}
+
+// uploadSidecar uploads the sidecar next to its audio
+func uploadSidecar(ctx context.Context, store BlobStore, sidecar Sidecar) error {
+	b, err := json.Marshal(sidecar)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, sidecarKey(sidecar.Key), bytes.NewReader(b), "application/json", nil)
+}
+
+// RebuildArchive adds every sidecar in the store under prefix to the archive, returning the number
+// of calls archived. Sidecars that can't be read are logged and skipped.
+func RebuildArchive(ctx context.Context, store BlobStore, prefix string, archive *Archive) (int, error) {
+	var archived, skipped int
+	err := store.List(ctx, prefix, func(key string) error {
+		if path.Ext(key) != ".json" {
+			return nil
+		}
+
+		sidecar, err := readSidecar(ctx, store, key)
+		if err == nil {
+			err = archive.Add(ctx, sidecar.Key, sidecar.Metadata)
+		}
+		if err != nil {
+			log.Printf("[archive] Skipping sidecar %s: %v", key, err)
+			skipped++
+			return ctx.Err()
+		}
+		archived++
+		return nil
+	})
+	if skipped > 0 {
+		log.Printf("[archive] Rebuilt archive from %d sidecars, skipped %d", archived, skipped)
+	}
+	return archived, err
+}
+
+func readSidecar(ctx context.Context, store BlobStore, key string) (sidecar Sidecar, err error) {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return sidecar, err
+	}
+	defer body.Close()
+
+	if err := json.NewDecoder(body).Decode(&sidecar); err != nil {
+		return sidecar, err
+	}
+	if sidecar.Key == "" {
+		return sidecar, errors.New("missing audio key")
+	}
+	return sidecar, nil
+}
+
+// handleRebuildArchive serves POST /admin/archive/rebuild, re-indexing the sidecars under the
+// optional prefix query parameter
+func handleRebuildArchive(config *Config) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		archived, err := RebuildArchive(r.Context(), config.store, r.URL.Query().Get("prefix"), config.archive)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"archived": archived})
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecarRebuildsArchive(t *testing.T) {
+	ctx := context.Background()
+	store, fake := fakeS3Store(t, "")
+
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+	meta.AudioText = "3049 Bancroft, bike versus auto."
+	meta.Segments = []Segment{{Start: 0, End: 2.8, Text: "3049 Bancroft, bike versus auto."}}
+
+	require.NoError(t, uploadAudio(ctx, store, filename, bytes.NewReader([]byte("RIFF")), "audio/wav", meta))
+	sidecar := newSidecar(filename, meta, []SlackChannelID{BERKELEY})
+	require.NoError(t, uploadSidecar(ctx, store, sidecar))
+
+	b := fake.objects[blobBucket+"/Berkeley/2105/2105-1702705979_772093750.1-call_130267.json"]
+	require.NotNil(t, b, "sidecar should be next to the audio")
+	var stored Sidecar
+	require.NoError(t, json.Unmarshal(b, &stored))
+	assert.Equal(t, filename, stored.Key)
+	assert.Equal(t, meta.Segments, stored.Metadata.Segments)
+	assert.Equal(t, meta.SrcList, stored.Metadata.SrcList)
+	assert.Equal(t, "3049 Bancroft", stored.Address.PrimaryAddress)
+	assert.NotEmpty(t, stored.Mentions)
+
+	// an unreadable sidecar is skipped
+	fake.objects[blobBucket+"/Berkeley/2105/broken.json"] = []byte("{")
+
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	archive, err := NewArchive(ctx, db)
+	require.NoError(t, err)
+
+	archived, err := RebuildArchive(ctx, store, "Berkeley/", archive)
+	require.NoError(t, err)
+	assert.Equal(t, 1, archived)
+
+	results, err := archive.Search(ctx, SearchQuery{Text: "bancroft"})
+	require.NoError(t, err)
+	require.Len(t, results.Results, 1)
+	assert.Equal(t, filename, results.Results[0].Key)
+	assert.Equal(t, []int64{3124119, 3113008}, results.Results[0].Units)
+}
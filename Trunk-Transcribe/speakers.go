@@ -0,0 +1,120 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Utterance is a run of transcript attributed to the unit that was transmitting
+type Utterance struct {
+	Src     int64   `json:"src,omitempty"`
+	Speaker string  `json:"speaker,omitempty"` // the unit's tag, or its id if it has no tag
+	Start   float32 `json:"start,omitempty"`
+	End     float32 `json:"end,omitempty"`
+	Text    string  `json:"text,omitempty"`
+}
+
+// String formats the utterance as a transcript line, e.g. "Dispatch: Copy."
+func (u Utterance) String() string {
+	if u.Speaker == "" {
+		return u.Text
+	}
+	return u.Speaker + ": " + u.Text
+}
+
+// AttributeSpeakers assigns each segment to the source whose transmission window contains the
+// middle of the segment. A source transmits from its Pos until the next source's Pos. Consecutive
+// segments from the same source are merged into one utterance. Untimed segments can only be
+// attributed when there is a single source.
+func AttributeSpeakers(segments []Segment, srcList []Source) []Utterance {
+	sources := slices.Clone(srcList)
+	slices.SortStableFunc(sources, func(a, b Source) int {
+		switch {
+		case a.Pos < b.Pos:
+			return -1
+		case a.Pos > b.Pos:
+			return 1
+		}
+		return 0
+	})
+
+	var utterances []Utterance
+	for _, segment := range segments {
+		text := strings.TrimSpace(segment.Text)
+		if text == "" {
+			continue
+		}
+
+		utterance := Utterance{Start: segment.Start, End: segment.End, Text: text}
+		if src, ok := speakerAt(segment, sources); ok {
+			utterance.Src = src.Src
+			utterance.Speaker = src.Tag
+			if utterance.Speaker == "" {
+				utterance.Speaker = strconv.FormatInt(src.Src, 10)
+			}
+		}
+
+		if n := len(utterances); n > 0 && utterances[n-1].Speaker == utterance.Speaker {
+			utterances[n-1].End = utterance.End
+			utterances[n-1].Text += " " + utterance.Text
+			continue
+		}
+		utterances = append(utterances, utterance)
+	}
+	return utterances
+}
+
+// offsetSegments shifts the segment times later by seconds, e.g. back onto the timeline of the
+// original audio after leading silence was trimmed. Untimed segments are left untimed.
+func offsetSegments(segments []Segment, seconds float64) []Segment {
+	if seconds <= 0 {
+		return segments
+	}
+	offset := make([]Segment, len(segments))
+	for i, segment := range segments {
+		if segment.Start != 0 || segment.End != 0 {
+			segment.Start += float32(seconds)
+			segment.End += float32(seconds)
+		}
+		offset[i] = segment
+	}
+	return offset
+}
+
+// speakerAt returns the source transmitting at the middle of the segment. sources must be sorted
+// by Pos.
+func speakerAt(segment Segment, sources []Source) (Source, bool) {
+	if len(sources) == 0 {
+		return Source{}, false
+	} else if segment.Start == 0 && segment.End == 0 {
+		return sources[0], len(sources) == 1
+	}
+
+	mid := float64(segment.Start+segment.End) / 2
+	speaker := sources[0]
+	for _, src := range sources[1:] {
+		if src.Pos > mid {
+			break
+		}
+		speaker = src
+	}
+	return speaker, true
+}
+
+// speakerTranscript returns the call's transcript as one line per utterance. Suspect segments are
+// italicized.
+func speakerTranscript(meta Metadata) []string {
+	segments := slices.Clone(meta.Segments)
+	for i, segment := range segments {
+		if text := strings.TrimSpace(segment.Text); segment.Suspect != "" && text != "" {
+			segments[i].Text = "_" + text + "_"
+		}
+	}
+
+	var lines []string
+	for _, utterance := range AttributeSpeakers(segments, meta.SrcList) {
+		lines = append(lines, utterance.String())
+	}
+	return lines
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttributeSpeakers(t *testing.T) {
+	var meta Metadata
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+
+	tests := []struct {
+		name     string
+		segments []Segment
+		srcList  []Source
+		expect   []string
+	}{
+		{
+			name: "one segment per speaker",
+			segments: []Segment{
+				{Start: 0, End: 2.8, Text: " Berkeley, 2605 Durant."},
+				{Start: 2.9, End: 4.2, Text: " Copy."},
+			},
+			expect: []string{"3124119: Berkeley, 2605 Durant.", "Dispatch: Copy."},
+		},
+		{
+			name: "more segments than speakers",
+			segments: []Segment{
+				{Start: 0, End: 1.2, Text: " Berkeley,"},
+				{Start: 1.2, End: 2.8, Text: " 2605 Durant."},
+				{Start: 2.9, End: 4.2, Text: " Copy."},
+			},
+			expect: []string{"3124119: Berkeley, 2605 Durant.", "Dispatch: Copy."},
+		},
+		{
+			name: "fewer segments than speakers",
+			segments: []Segment{
+				{Start: 3, End: 4.2, Text: " Copy."},
+			},
+			expect: []string{"Dispatch: Copy."},
+		},
+		{
+			name: "segment straddling speakers goes to the one speaking at its middle",
+			segments: []Segment{
+				{Start: 0, End: 3.2, Text: " Berkeley, 2605 Durant."},
+				{Start: 2.4, End: 4.2, Text: " Copy."},
+			},
+			expect: []string{"3124119: Berkeley, 2605 Durant.", "Dispatch: Copy."},
+		},
+		{
+			name:     "untimed segment with several speakers is unattributed",
+			segments: []Segment{{Text: "Berkeley, 2605 Durant. Copy."}},
+			expect:   []string{"Berkeley, 2605 Durant. Copy."},
+		},
+		{
+			name:     "untimed segment with one speaker",
+			segments: []Segment{{Text: "Copy."}},
+			srcList:  meta.SrcList[1:],
+			expect:   []string{"Dispatch: Copy."},
+		},
+		{
+			name:     "no sources",
+			segments: []Segment{{Start: 0, End: 1, Text: "Copy."}},
+			srcList:  []Source{},
+			expect:   []string{"Copy."},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := meta
+			meta.Segments = test.segments
+			if test.srcList != nil {
+				meta.SrcList = test.srcList
+			}
+			assert.Equal(t, test.expect, speakerTranscript(meta))
+		})
+	}
+}
+
+func TestAttributeSpeakersTrimmedSilence(t *testing.T) {
+	srcList := []Source{{Src: 1, Pos: 0, Tag: "Engine 2"}, {Src: 2, Pos: 3, Tag: "Dispatch"}}
+
+	// timed on the enhanced audio, 1.5s of leading silence earlier than the srcList positions
+	segments := []Segment{
+		{Start: 0, End: 1.2, Text: " Engine 2 on scene."},
+		{Start: 1.6, End: 2.8, Text: " Copy."},
+		{},
+	}
+	assert.Equal(t, []Utterance{{Src: 1, Speaker: "Engine 2", End: 2.8, Text: "Engine 2 on scene. Copy."}},
+		AttributeSpeakers(segments, srcList))
+
+	offset := offsetSegments(segments, 1.5)
+	assert.Equal(t, []Utterance{
+		{Src: 1, Speaker: "Engine 2", Start: 1.5, End: 2.7, Text: "Engine 2 on scene."},
+		{Src: 2, Speaker: "Dispatch", Start: 3.1, End: 4.3, Text: "Copy."},
+	}, AttributeSpeakers(offset, srcList))
+	assert.Equal(t, Segment{}, offset[2], "untimed segments stay untimed")
+	assert.Equal(t, float32(0), segments[0].Start, "the transcript's segments are not modified")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// format calls are transcoded to for storage and Slack: "mp3", "opus" or "none" to keep the
+// original. Rdio always receives the original.
+var transcodeFormat string = getenv("TRANSCODE_FORMAT", "mp3")
+
+// how long transcoding may take before the original is used instead
+var transcodeTimeout = 10 * time.Second
+
+// AudioFormat describes an encoding calls can be transcoded to
+type AudioFormat struct {
+	Ext         string
+	ContentType string
+	args        ffmpeg.KwArgs
+}
+
+// mono at bitrates that keep radio voice intelligible
+var audioFormats = map[string]AudioFormat{
+	"mp3": {
+		Ext:         ".mp3",
+		ContentType: "audio/mpeg",
+		args:        ffmpeg.KwArgs{"format": "mp3", "c:a": "libmp3lame", "b:a": "32k", "ac": 1},
+	},
+	"opus": {
+		Ext:         ".ogg",
+		ContentType: "audio/ogg",
+		args:        ffmpeg.KwArgs{"format": "ogg", "c:a": "libopus", "b:a": "24k", "ac": 1, "application": "voip"},
+	},
+}
+
+// audioContentType returns the content type of the audio at key from its extension
+func audioContentType(key string) string {
+	switch ext := strings.ToLower(path.Ext(key)); ext {
+	case ".wav":
+		return "audio/wav"
+	case ".m4a":
+		return "audio/mp4"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".ogg", ".opus":
+		return "audio/ogg"
+	default:
+		if contentType := mime.TypeByExtension(ext); contentType != "" {
+			return contentType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// storedAudio returns the key, audio and content type to archive and post for the call at key,
+// transcoded to TRANSCODE_FORMAT. The original is returned if transcoding is disabled or fails.
+func storedAudio(ctx context.Context, key string, data []byte) (string, []byte, string) {
+	format, ok := audioFormats[transcodeFormat]
+	if !ok || strings.EqualFold(path.Ext(key), format.Ext) {
+		return key, data, audioContentType(key)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, transcodeTimeout)
+	defer cancel()
+
+	transcoded, err := transcode(ctx, data, format)
+	if err != nil {
+		log.Printf("[transcode] Error transcoding %s to %s, keeping the original: %v", key, transcodeFormat, err)
+		return key, data, audioContentType(key)
+	}
+	return strings.TrimSuffix(key, path.Ext(key)) + format.Ext, transcoded, format.ContentType
+}
+
+// transcode converts the audio to format with ffmpeg
+func transcode(ctx context.Context, data []byte, format AudioFormat) ([]byte, error) {
+	args := ffmpeg.KwArgs{"hide_banner": "", "loglevel": "error"}
+	for k, v := range format.args {
+		args[k] = v
+	}
+
+	var out, stderr bytes.Buffer
+	stream := ffmpeg.Input("pipe:")
+	stream.Context = ctx
+	err := stream.
+		WithInput(bytes.NewReader(data)).
+		Output("pipe:", args).
+		WithOutput(&out, &stderr).
+		Silent(true).
+		Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg: no output: %s", strings.TrimSpace(stderr.String()))
+	}
+	return out.Bytes(), nil
+}
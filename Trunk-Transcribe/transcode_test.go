@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoredAudio(t *testing.T) {
+	ctx := context.Background()
+	wav, err := base64.StdEncoding.DecodeString(silenceAudio)
+	require.NoError(t, err)
+
+	defer func(format string) { transcodeFormat = format }(transcodeFormat)
+
+	tests := []struct {
+		name        string
+		format      string
+		key         string
+		data        []byte
+		expectKey   string
+		contentType string
+		transcoded  bool
+		ffmpeg      bool
+	}{
+		{
+			name:        "disabled",
+			format:      "none",
+			key:         "Berkeley/3105/call.wav",
+			data:        wav,
+			expectKey:   "Berkeley/3105/call.wav",
+			contentType: "audio/wav",
+		},
+		{
+			name:        "already in format",
+			format:      "mp3",
+			key:         "Berkeley/3105/call.MP3",
+			data:        []byte("ID3"),
+			expectKey:   "Berkeley/3105/call.MP3",
+			contentType: "audio/mpeg",
+		},
+		{
+			name:        "failure keeps original",
+			format:      "opus",
+			key:         "Berkeley/3105/call.m4a",
+			data:        []byte("not audio"),
+			expectKey:   "Berkeley/3105/call.m4a",
+			contentType: "audio/mp4",
+		},
+		{
+			name:        "mp3",
+			format:      "mp3",
+			key:         "Berkeley/3105/call.wav",
+			data:        wav,
+			expectKey:   "Berkeley/3105/call.mp3",
+			contentType: "audio/mpeg",
+			transcoded:  true,
+			ffmpeg:      true,
+		},
+		{
+			name:        "opus",
+			format:      "opus",
+			key:         "Berkeley/3105/call.wav",
+			data:        wav,
+			expectKey:   "Berkeley/3105/call.ogg",
+			contentType: "audio/ogg",
+			transcoded:  true,
+			ffmpeg:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := exec.LookPath("ffmpeg"); err != nil && test.ffmpeg {
+				t.Skip("ffmpeg not installed")
+			}
+			transcodeFormat = test.format
+
+			key, data, contentType := storedAudio(ctx, test.key, test.data)
+			assert.Equal(t, test.expectKey, key)
+			assert.Equal(t, test.contentType, contentType)
+			if test.transcoded {
+				assert.NotEmpty(t, data)
+				assert.Less(t, len(data), len(test.data))
+			} else {
+				assert.Equal(t, test.data, data)
+			}
+		})
+	}
+}
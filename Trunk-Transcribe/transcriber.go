@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// transcribersConfigPath optionally points at a transcriber selection file that replaces the
+// embedded default
+var transcribersConfigPath string = os.Getenv("TRANSCRIBERS_CONFIG")
+
+//go:embed config/transcribers.json
+var defaultTranscribersConfig []byte
+
+// Transcription is the text of a call along with its timed segments
+type Transcription struct {
+	Text     string         `json:"text,omitempty"`
+	Segments []Segment      `json:"segments,omitempty"`
+	Backend  string         `json:"backend,omitempty"` // name of the transcriber that produced the text
+	Dropped  map[string]int `json:"dropped,omitempty"` // segments dropped or marked by SegmentFilter, by reason
+}
+
+// Transcriber converts call audio to text
+type Transcriber interface {
+	Name() string
+	Transcribe(ctx context.Context, data []byte) (Transcription, error)
+}
+
+// Structures to parse transcriber selection json of the form:
+//
+//	{
+//	  "timeout": "45s",
+//	  "default": ["cloudflare", "gemini", "local"],
+//	  "systems": {"Oakland": ["local"]},
+//	  "talkgroups": {"3105": ["gemini", "cloudflare"]},
+//	  "filter": {"max_no_speech_prob": 0.6, "min_avg_logprob": -1.5, "max_compression_ratio": 2.4}
+//	}
+//
+// Each selection is a fallback chain: the next transcriber is tried when one errors, runs past
+// the timeout, or returns empty or hallucinated text. A talkgroup selection takes precedence over
+// a system (short_name) selection, which takes precedence over the default. Names refer to the
+// backends registered at startup. Every result is passed through the filter before it is judged.
+type TranscribersConfig struct {
+	Timeout    Duration            `json:"timeout,omitempty"`
+	Default    []string            `json:"default"`
+	Systems    map[string][]string `json:"systems,omitempty"`
+	Talkgroups map[int64][]string  `json:"talkgroups,omitempty"`
+	Filter     SegmentFilter       `json:"filter"`
+}
+
+// Duration is a time.Duration that unmarshals from a json string such as "45s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = Duration(parsed)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Transcribers selects the fallback chain of transcribers to use for a call
+type Transcribers struct {
+	backends map[string]Transcriber
+	config   TranscribersConfig
+}
+
+// NewTranscribers validates that every backend named in the selection config is registered
+func NewTranscribers(config TranscribersConfig, backends ...Transcriber) (*Transcribers, error) {
+	t := &Transcribers{
+		backends: make(map[string]Transcriber, len(backends)),
+		config:   config,
+	}
+	for _, backend := range backends {
+		t.backends[backend.Name()] = backend
+	}
+
+	var errs []error
+	check := func(where string, chain []string) {
+		if len(chain) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no transcribers", where))
+		}
+		for _, name := range chain {
+			if _, ok := t.backends[name]; !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown transcriber %q", where, name))
+			}
+		}
+	}
+	check("default", config.Default)
+	for system, chain := range config.Systems {
+		check("system "+system, chain)
+	}
+	for tg, chain := range config.Talkgroups {
+		check(fmt.Sprintf("talkgroup %d", tg), chain)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseTranscribersConfig parses transcriber selection json
+func ParseTranscribersConfig(b []byte) (config TranscribersConfig, err error) {
+	err = json.Unmarshal(b, &config)
+	return config, err
+}
+
+// LoadTranscribersConfig reads the selection file at path, or the embedded default if path is empty
+func LoadTranscribersConfig(path string) (TranscribersConfig, error) {
+	if path == "" {
+		return ParseTranscribersConfig(defaultTranscribersConfig)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return TranscribersConfig{}, err
+	}
+	config, err := ParseTranscribersConfig(b)
+	if err != nil {
+		return config, fmt.Errorf("transcribers config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// For returns the fallback chain of transcribers selected for the call described by meta
+func (t *Transcribers) For(meta Metadata) []Transcriber {
+	chain := t.config.Default
+	if names, ok := t.config.Talkgroups[meta.Talkgroup]; ok {
+		chain = names
+	} else {
+		for system, names := range t.config.Systems {
+			if strings.EqualFold(system, meta.ShortName) {
+				chain = names
+				break
+			}
+		}
+	}
+
+	transcribers := make([]Transcriber, len(chain))
+	for i, name := range chain {
+		transcribers[i] = t.backends[name]
+	}
+	return transcribers
+}
+
+// Transcribe transcribes the call with its fallback chain, returning the first result with usable
+// text. If every transcriber ran but none produced usable text, the call is most likely silent and
+// an empty transcription is returned without error. An error is only returned if every transcriber
+// failed.
+func (t *Transcribers) Transcribe(ctx context.Context, meta Metadata, data []byte) (Transcription, error) {
+	var errs []error
+	var empty *Transcription
+	for _, backend := range t.For(meta) {
+		transcription, err := t.transcribe(ctx, backend, data)
+		switch {
+		case ctx.Err() != nil:
+			return transcription, errors.Join(append(errs, ctx.Err())...)
+		case err != nil:
+			log.Printf("[transcribe] %s failed, falling back: %v", backend.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+		case !hasSpeech(transcription):
+			log.Printf("[transcribe] %s returned no usable text, falling back: %q", backend.Name(), transcription.Text)
+			if empty == nil {
+				empty = &transcription
+			}
+		default:
+			return transcription, nil
+		}
+	}
+
+	if empty != nil {
+		return *empty, nil
+	}
+	return Transcription{}, errors.Join(errs...)
+}
+
+// transcribe runs a single transcriber, bounded by the configured timeout
+func (t *Transcribers) transcribe(ctx context.Context, backend Transcriber, data []byte) (Transcription, error) {
+	if t.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(t.config.Timeout))
+		defer cancel()
+	}
+	transcription, err := backend.Transcribe(ctx, data)
+	if err != nil {
+		return transcription, err
+	}
+	transcription = t.config.Filter.Apply(transcription)
+	transcription.Backend = backend.Name()
+	return transcription, nil
+}
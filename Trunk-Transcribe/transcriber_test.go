@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cloudflareStandIn serves a canned Cloudflare Whisper response
+func cloudflareStandIn(t *testing.T, output CloudflareWhisperOutput) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		var input CloudflareWhisperInput
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+		assert.NotEmpty(t, input.Audio)
+
+		json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCloudflareWhisper(t *testing.T) {
+	server := cloudflareStandIn(t, CloudflareWhisperOutput{
+		Success: true,
+		Result: TranscriptionInfo{
+			Text: "Berkeley, 2605 Durant. Copy.",
+			Segments: []Segment{
+				{Start: 0, End: 2.8, Text: "Berkeley, 2605 Durant."},
+				{Start: 2.9, End: 4.2, Text: "Copy."},
+			},
+		},
+	})
+
+	whisper := &CloudflareWhisper{URL: server.URL, Token: "token"}
+	transcription, err := whisper.Transcribe(context.Background(), []byte("RIFF"))
+	require.NoError(t, err)
+	assert.Equal(t, "Berkeley, 2605 Durant. Copy.", transcription.Text)
+	require.Len(t, transcription.Segments, 2)
+	assert.Equal(t, float32(2.9), transcription.Segments[1].Start)
+}
+
+func TestLocalWhisper(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		expect Transcription
+	}{
+		{
+			name:   "whisper.cpp json file",
+			script: `test -s {input} && echo '{"transcription": [{"offsets": {"from": 0, "to": 1500}, "text": " Engine 2"}, {"offsets": {"from": 1500, "to": 3000}, "text": " on scene"}]}' > {output}.json`,
+			expect: Transcription{
+				Text: "Engine 2 on scene",
+				Segments: []Segment{
+					{Start: 0, End: 1.5, Text: " Engine 2"},
+					{Start: 1.5, End: 3, Text: " on scene"},
+				},
+			},
+		},
+		{
+			name:   "verbose json stdout",
+			script: `echo '{"text": "Engine 2 on scene", "segments": [{"start": 0, "end": 3, "text": "Engine 2 on scene"}]}'`,
+			expect: Transcription{
+				Text:     "Engine 2 on scene",
+				Segments: []Segment{{Start: 0, End: 3, Text: "Engine 2 on scene"}},
+			},
+		},
+		{
+			name:   "plain text stdout",
+			script: `echo 'Engine 2 on scene'`,
+			expect: Transcription{
+				Text:     "Engine 2 on scene",
+				Segments: []Segment{{Text: "Engine 2 on scene"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			local := &LocalWhisper{Command: "sh", Args: []string{"-c", test.script}}
+			transcription, err := local.Transcribe(context.Background(), []byte("RIFF"))
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, transcription)
+		})
+	}
+}
+
+// geminiStandIn serves a canned Gemini generateContent response
+func geminiStandIn(t *testing.T, text string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.URL.Path, "gemini-test:generateContent")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"candidates": []any{
+				map[string]any{"content": map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}}},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTranscriberSelection(t *testing.T) {
+	config, err := ParseTranscribersConfig([]byte(`{"timeout": "30s", "default": ["cloudflare", "gemini"], "systems": {"oakland": ["local"]}, "talkgroups": {"3105": ["gemini", "local"]}}`))
+	require.NoError(t, err)
+	assert.Equal(t, Duration(30*time.Second), config.Timeout)
+
+	transcribers, err := NewTranscribers(config, &CloudflareWhisper{}, &Gemini{}, &LocalWhisper{})
+	require.NoError(t, err)
+
+	names := func(meta Metadata) (names []string) {
+		for _, transcriber := range transcribers.For(meta) {
+			names = append(names, transcriber.Name())
+		}
+		return names
+	}
+	assert.Equal(t, []string{"gemini", "local"}, names(Metadata{Talkgroup: 3105, ShortName: "Oakland"}))
+	assert.Equal(t, []string{"local"}, names(Metadata{Talkgroup: 3405, ShortName: "Oakland"}))
+	assert.Equal(t, []string{"cloudflare", "gemini"}, names(Metadata{Talkgroup: 2105, ShortName: "Berkeley"}))
+
+	config.Talkgroups[2105] = []string{"cloudflare", "openai"}
+	_, err = NewTranscribers(config, &CloudflareWhisper{}, &Gemini{}, &LocalWhisper{})
+	assert.EqualError(t, err, `talkgroup 2105: unknown transcriber "openai"`)
+}
+
+func TestTranscriberFallback(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+	silent := cloudflareStandIn(t, CloudflareWhisperOutput{Success: true, Result: TranscriptionInfo{Text: " Thank you. Thank you."}})
+	gemini := geminiStandIn(t, "Engine 2 on scene")
+
+	local := &LocalWhisper{Command: "sh", Args: []string{"-c", "echo 'Engine 2 on scene'"}}
+	broken := &LocalWhisper{Command: "sh", Args: []string{"-c", "exit 1"}}
+
+	tests := []struct {
+		name        string
+		cloudflare  string
+		local       *LocalWhisper
+		expect      string
+		transcriber string
+		wantErr     bool
+	}{
+		{
+			name:        "error falls back",
+			cloudflare:  failing.URL,
+			local:       local,
+			expect:      "Engine 2 on scene",
+			transcriber: "gemini",
+		},
+		{
+			name:        "timeout falls back",
+			cloudflare:  hanging.URL,
+			local:       local,
+			expect:      "Engine 2 on scene",
+			transcriber: "gemini",
+		},
+		{
+			name:        "hallucination falls back",
+			cloudflare:  silent.URL,
+			local:       local,
+			expect:      "Engine 2 on scene",
+			transcriber: "gemini",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := TranscribersConfig{Timeout: Duration(100 * time.Millisecond), Default: []string{"cloudflare", "gemini", "local"}}
+			transcribers, err := NewTranscribers(config,
+				&CloudflareWhisper{URL: test.cloudflare, Token: "token"},
+				&Gemini{APIKey: "key", Model: "gemini-test", Endpoint: gemini.URL},
+				test.local,
+			)
+			require.NoError(t, err)
+
+			transcription, err := transcribers.Transcribe(context.Background(), Metadata{Talkgroup: 2105}, []byte("RIFF"))
+			assert.Equal(t, test.wantErr, err != nil, err)
+			assert.Equal(t, test.expect, transcription.Text)
+			assert.Equal(t, test.transcriber, transcription.Backend)
+		})
+	}
+
+	t.Run("all failing", func(t *testing.T) {
+		config := TranscribersConfig{Default: []string{"cloudflare", "local"}}
+		transcribers, err := NewTranscribers(config, &CloudflareWhisper{URL: failing.URL}, broken)
+		require.NoError(t, err)
+
+		_, err = transcribers.Transcribe(context.Background(), Metadata{}, []byte("RIFF"))
+		assert.ErrorContains(t, err, "cloudflare: ")
+		assert.ErrorContains(t, err, "local: ")
+	})
+
+	t.Run("all silent", func(t *testing.T) {
+		config := TranscribersConfig{Default: []string{"cloudflare", "local"}}
+		transcribers, err := NewTranscribers(config, &CloudflareWhisper{URL: silent.URL, Token: "token"}, broken)
+		require.NoError(t, err)
+
+		transcription, err := transcribers.Transcribe(context.Background(), Metadata{}, []byte("RIFF"))
+		require.NoError(t, err)
+		assert.Equal(t, "cloudflare", transcription.Backend)
+	})
+}
@@ -78,17 +78,6 @@ const (
 
 type SlackUserID string
 
-const (
-	EMILIE  SlackUserID = "U06H9NA2L4V"
-	MARC                = "U03FTUS9SSD"
-	NAVEEN              = "U0531U1RY1W"
-	JOSE                = "U073Q372CP9"
-	STEPHAN             = "U06UWE5EDAT"
-	HELEN               = "U08155VNVRQ"
-	TAJ                 = "U08V90KL9SS"
-	FORD                = "U0BAB73D56Z"
-)
-
 // Structures to parse json of the form:
 //
 //	{
@@ -146,9 +135,12 @@ const (
 //	  ]
 //	}
 type Frequency struct {
-	Freq int64   `json:"freq,omitempty"`
-	Time int64   `json:"time,omitempty"`
-	Pos  float64 `json:"pos,omitempty"`
+	Freq       int64   `json:"freq,omitempty"`
+	Time       int64   `json:"time,omitempty"`
+	Pos        float64 `json:"pos,omitempty"`
+	Len        float64 `json:"len,omitempty"`
+	ErrorCount Count   `json:"error_count,omitempty"`
+	SpikeCount Count   `json:"spike_count,omitempty"`
 }
 type Source struct {
 	Src          int64   `json:"src,omitempty"`
@@ -160,27 +152,29 @@ type Source struct {
 }
 
 type Metadata struct {
-	Freq              int64       `json:"freq,omitempty"`
-	StartTime         int64       `json:"start_time,omitempty"`
-	StopTime          int64       `json:"stop_time,omitempty"`
-	Emergency         int64       `json:"emergency,omitempty"`
-	Priority          int64       `json:"priority,omitempty"`
-	Mode              int64       `json:"mode,omitempty"`
-	Duplex            int64       `json:"duplex,omitempty"`
-	Encrypted         int64       `json:"encrypted,omitempty"`
-	CallLength        int64       `json:"call_length,omitempty"`
-	Talkgroup         int64       `json:"talkgroup,omitempty"`
-	TalkgroupTag      string      `json:"talkgroup_tag,omitempty"`
-	TalkGroupDesc     string      `json:"talkgroup_description,omitempty"`
-	TalkGroupGroupTag string      `json:"talkgroup_group_tag,omitempty"`
-	TalkGroupGroup    string      `json:"talkgroup_group,omitempty"`
-	AudioType         string      `json:"audio_type,omitempty"`
-	ShortName         string      `json:"short_name,omitempty"`
-	AudioText         string      `json:"audio_text,omitempty"`
-	URL               string      `json:"url,omitempty"`
-	SrcList           []Source    `json:"srcList,omitempty"`
-	FreqList          []Frequency `json:"freqList,omitempty"`
-	Segments          []string    `json:"segments,omitempty"`
+	Freq              int64          `json:"freq,omitempty"`
+	StartTime         int64          `json:"start_time,omitempty"`
+	StopTime          int64          `json:"stop_time,omitempty"`
+	Emergency         int64          `json:"emergency,omitempty"`
+	Priority          int64          `json:"priority,omitempty"`
+	Mode              int64          `json:"mode,omitempty"`
+	Duplex            int64          `json:"duplex,omitempty"`
+	Encrypted         int64          `json:"encrypted,omitempty"`
+	CallLength        int64          `json:"call_length,omitempty"`
+	Talkgroup         int64          `json:"talkgroup,omitempty"`
+	TalkgroupTag      string         `json:"talkgroup_tag,omitempty"`
+	TalkGroupDesc     string         `json:"talkgroup_description,omitempty"`
+	TalkGroupGroupTag string         `json:"talkgroup_group_tag,omitempty"`
+	TalkGroupGroup    string         `json:"talkgroup_group,omitempty"`
+	AudioType         string         `json:"audio_type,omitempty"`
+	ShortName         string         `json:"short_name,omitempty"`
+	AudioText         string         `json:"audio_text,omitempty"`
+	Transcriber       string         `json:"transcriber,omitempty"` // backend that produced AudioText
+	DroppedSegments   map[string]int `json:"dropped_segments,omitempty"`
+	URL               string         `json:"url,omitempty"`
+	SrcList           []Source       `json:"srcList,omitempty"`
+	FreqList          []Frequency    `json:"freqList,omitempty"`
+	Segments          []Segment      `json:"segments,omitempty"`
 }
 
 type Notifs struct {
@@ -189,6 +183,7 @@ type Notifs struct {
 	NotRegex   *regexp.Regexp
 	Channels   []SlackChannelID //the channels to listen on
 	TalkGroups []TalkGroupID    //individual talkgroups to listen to (could be exclusive of channels)
+	Notify     []string         //sinks to send matching calls to
 }
 
 func (n Notifs) MatchesText(channelID SlackChannelID, talkgroupID TalkGroupID, text string, words []string) bool {
@@ -219,8 +214,9 @@ func (n Notifs) MatchesText(channelID SlackChannelID, talkgroupID TalkGroupID, t
 }
 
 type SlackMeta struct {
-	Mentions []string `json:"mentions,omitempty"`
-	Address  Address  `json:"address,omitempty"`
+	Mentions []string        `json:"mentions,omitempty"`
+	Address  Address         `json:"address,omitempty"`
+	Details  IncidentDetails `json:"details,omitempty"`
 }
 
 // Address struct encapsulates address info to extract from transcription text
@@ -229,6 +225,8 @@ type Address struct {
 	City           string   `json:"city,omitempty"`
 	PrimaryAddress string   `json:"primary,omitempty"`
 	Streets        []string `json:"streets,omitempty"`
+	Normalized     string   `json:"normalized,omitempty"` // set by the geocoder, e.g. "2605 Durant Ave, Berkeley"
+	Location       *LatLon  `json:"location,omitempty"`
 }
 
 func (addr Address) AppendStreet(street string) Address {
@@ -259,6 +257,7 @@ type Segment struct {
 	AvgLogProb        float64 `json:"avg_logprob,omitempty"`
 	NoSpeechProb      float64 `json:"no_speech_prob,omitempty"`
 	CompressionRation float64 `json:"compression_ratio,omitempty"`
+	Suspect           string  `json:"suspect,omitempty"` // why the segment is not trusted, see SegmentFilter
 }
 
 type TranscriptionInfo struct {
@@ -288,7 +287,18 @@ type TranscriptionRequest struct {
 	Meta          Metadata
 	Transcribe    bool // transcribe the audio
 	SlackChannels []SlackChannelID
-	UploadToRdio  bool // whether or not this call should be uploaded to rdio
+	UploadToRdio  bool     // whether or not this call should be uploaded to rdio
+	Notify        bool     // send the call to notification sinks. Only one source of a call does
+	Sinks         []string // notification sinks the call is routed to
+	Steps         []string // the steps to run, all if empty. Set when replaying a dead letter
+
+	// file ids of the call's slack posts by channel, which a transcript replay replies to
+	Posted map[SlackChannelID]string
+}
+
+// Runs returns whether the request should run the step
+func (t *TranscriptionRequest) Runs(step string) bool {
+	return len(t.Steps) == 0 || slices.Contains(t.Steps, step)
 }
 
 func (t *TranscriptionRequest) FilePath() string {